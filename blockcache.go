@@ -0,0 +1,124 @@
+package strigo
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/veyselaksin/strigo/v2/internal/db"
+)
+
+// blockCache remembers keys denied by the storage backend in process, so
+// Consume can deny them again without a network call until they have points
+// again. It holds at most size keys and evicts the least recently used one
+type blockCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // Most recently used at the front
+}
+
+type blockCacheEntry struct {
+	key      string
+	until    time.Time
+	consumed int64
+	points   int64 // Points of the denied request, smaller requests may pass
+}
+
+func newBlockCache(size int) *blockCache {
+	return &blockCache{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// get returns the entry of key when it is still blocked at now
+func (c *blockCache) get(key string, now time.Time) (blockCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return blockCacheEntry{}, false
+	}
+
+	entry := elem.Value.(*blockCacheEntry)
+	if !now.Before(entry.until) {
+		c.removeElement(elem)
+		return blockCacheEntry{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	return *entry, true
+}
+
+// add blocks requests for at least points from key until the given time,
+// evicting the least recently used key when the cache is full
+func (c *blockCache) add(key string, until time.Time, consumed, points int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*blockCacheEntry)
+		entry.until = until
+		entry.consumed = consumed
+		entry.points = points
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	if c.order.Len() >= c.size {
+		c.removeElement(c.order.Back())
+	}
+
+	entry := &blockCacheEntry{key: key, until: until, consumed: consumed, points: points}
+	c.entries[key] = c.order.PushFront(entry)
+}
+
+// remove forgets key, e.g. after it was reset or rewarded
+func (c *blockCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *blockCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*blockCacheEntry).key)
+}
+
+// cachedDenial returns the denial of a request for points from a key blocked
+// in the block cache, or nil when Consume has to ask the storage backend.
+// Requests for fewer points than the denied one may be admitted sooner, so
+// they always reach the storage
+func (rl *RateLimiter) cachedDenial(key string, points int64) *Result {
+	if rl.blocked == nil {
+		return nil
+	}
+
+	now := rl.opts.Clock.Now()
+	entry, ok := rl.blocked.get(key, now)
+	if !ok || points < entry.points {
+		return nil
+	}
+
+	return rl.newResult(&db.AtomicResult{
+		MsBeforeNext:   entry.until.Sub(now).Milliseconds(),
+		ConsumedPoints: entry.consumed,
+	})
+}
+
+// cacheDenial remembers result, the outcome of a request for points, in the
+// block cache when it leaves no points to consume until MsBeforeNext has passed
+func (rl *RateLimiter) cacheDenial(key string, points int64, result *Result) {
+	if rl.blocked == nil || result == nil || result.Allowed || result.RemainingPoints > 0 || result.MsBeforeNext <= 0 {
+		return
+	}
+
+	until := rl.opts.Clock.Now().Add(time.Duration(result.MsBeforeNext) * time.Millisecond)
+	rl.blocked.add(key, until, result.ConsumedPoints, points)
+}
@@ -0,0 +1,10 @@
+package strigo
+
+import "github.com/veyselaksin/strigo/v2/internal/db"
+
+// Clock tells the current time to the strategies and the memory store.
+// The clocktest package provides a Clock that is advanced manually
+type Clock = db.Clock
+
+// SystemClock is the default Clock, backed by time.Now
+var SystemClock Clock = db.SystemClock
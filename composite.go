@@ -0,0 +1,159 @@
+package strigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Composite enforces several limits on the same key at once, e.g.
+// 10 per second AND 1000 per hour AND 10000 per day
+// Similar to RateLimiterUnion from rate-limiter-flexible
+type Composite struct {
+	limiters []*RateLimiter
+}
+
+// NewComposite creates a composite limiter over the given limiters. They are
+// consumed in order, so put the cheapest or most often exceeded one first.
+// The limiters are not closed by the composite
+//
+// Limiters sharing a storage backend need distinct KeyPrefix values, otherwise
+// they update the same state and block keys and every request counts twice.
+// Limiters with the same prefix on the same Store or StoreClient are rejected;
+// separate clients connected to the same server cannot be detected
+func NewComposite(limiters ...*RateLimiter) (*Composite, error) {
+	if len(limiters) == 0 {
+		return nil, fmt.Errorf("composite limiter needs at least one limiter")
+	}
+	for i, limiter := range limiters {
+		if limiter == nil {
+			return nil, fmt.Errorf("limiter %d is nil", i)
+		}
+		for j, other := range limiters[:i] {
+			if other.opts.KeyPrefix == limiter.opts.KeyPrefix && sharesStorage(other, limiter) {
+				return nil, fmt.Errorf("limiters %d and %d share the key prefix %q on one storage backend", j, i, limiter.opts.KeyPrefix)
+			}
+		}
+	}
+
+	return &Composite{limiters: limiters}, nil
+}
+
+// sharesStorage reports whether a and b store their keys in the same backend,
+// as far as it can be told from the store and client they were created with
+func sharesStorage(a, b *RateLimiter) bool {
+	return sameValue(a.storage, b.storage) ||
+		(a.opts.StoreClient != nil && sameValue(a.opts.StoreClient, b.opts.StoreClient))
+}
+
+// sameValue compares a and b with ==, treating values of types that cannot be
+// compared as different instead of panicking
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil || reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// Consume consumes the points from every limiter. When a limiter denies the
+// request, the points already consumed from the limiters before it are given
+// back and its result is returned. Otherwise the result of the most
+// restrictive limiter (fewest remaining points) is returned
+func (c *Composite) Consume(key string, points ...int64) (*Result, error) {
+	return c.ConsumeCtx(context.Background(), key, points...)
+}
+
+// ConsumeCtx is like Consume but passes ctx to the storage backends
+func (c *Composite) ConsumeCtx(ctx context.Context, key string, points ...int64) (*Result, error) {
+	var combined *Result
+	admitted := make([]*Result, 0, len(c.limiters))
+	for _, limiter := range c.limiters {
+		result, err := limiter.ConsumeCtx(ctx, key, points...)
+		if err != nil {
+			return nil, c.refund(ctx, admitted, key, points, err)
+		}
+		if !result.Allowed {
+			return result, c.refund(ctx, admitted, key, points, nil)
+		}
+		admitted = append(admitted, result)
+		if combined == nil || moreRestrictive(result, combined) {
+			combined = result
+		}
+	}
+
+	return combined, nil
+}
+
+// refund gives the points back to the limiters that admitted the request,
+// with their results in order, after the next limiter denied it or failed
+// with err. Fixed window and sliding window counter limiters get them back
+// only in the window they were consumed in, so a window started since then
+// does not lose its count
+func (c *Composite) refund(ctx context.Context, admitted []*Result, key string, points []int64, err error) error {
+	refundPoints := int64(1)
+	if len(points) > 0 {
+		refundPoints = points[0]
+	}
+	if refundPoints <= 0 {
+		return err
+	}
+
+	errs := []error{err}
+	for i, result := range admitted {
+		if cancelErr := c.limiters[i].cancelConsume(ctx, key, refundPoints, result.slot); cancelErr != nil {
+			errs = append(errs, fmt.Errorf("failed to refund points: %w", cancelErr))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Get returns the state of the most restrictive limiter without consuming
+// points, or nil when no limiter has state for key
+func (c *Composite) Get(key string) (*Result, error) {
+	return c.GetCtx(context.Background(), key)
+}
+
+// GetCtx is like Get but passes ctx to the storage backends
+func (c *Composite) GetCtx(ctx context.Context, key string) (*Result, error) {
+	var combined *Result
+	for _, limiter := range c.limiters {
+		result, err := limiter.GetCtx(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil && (combined == nil || moreRestrictive(result, combined)) {
+			combined = result
+		}
+	}
+
+	return combined, nil
+}
+
+// Reset resets the key in every limiter
+func (c *Composite) Reset(key string) error {
+	return c.ResetCtx(context.Background(), key)
+}
+
+// ResetCtx is like Reset but passes ctx to the storage backends
+func (c *Composite) ResetCtx(ctx context.Context, key string) error {
+	for _, limiter := range c.limiters {
+		if err := limiter.ResetCtx(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moreRestrictive reports whether a limits the key more than b: denied
+// results come first, then the fewest remaining points, then the longest
+// wait before the next action
+func moreRestrictive(a, b *Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if a.RemainingPoints != b.RemainingPoints {
+		return a.RemainingPoints < b.RemainingPoints
+	}
+	return a.MsBeforeNext > b.MsBeforeNext
+}
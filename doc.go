@@ -16,6 +16,7 @@ backends and provides detailed information about rate limit status.
   - Leaky Bucket: Constant drain rate with request queueing
   - Sliding Window: Precise timestamp tracking
   - Fixed Window: Counter reset at intervals
+  - Sliding Window Counter: Weighted fixed window counts in constant storage
   - Flexible storage backends (Memory, Redis, Memcached)
   - Point-based system for variable cost operations
   - Detailed result information with standard HTTP headers
@@ -54,6 +55,8 @@ rate, providing smooth traffic flow regardless of arrival patterns.
 
 Technical: Maintains a queue of pending requests that drain at a fixed rate.
 Requests are processed at exactly 1 request per (Duration/Points) seconds.
+An allowed result reports the delay until the slot of the request in
+MsBeforeNext; with ExecuteEvenly set, Consume waits for the slot itself.
 
 ## Sliding Window
 
@@ -85,6 +88,23 @@ intervals aligned to calendar boundaries.
 Technical: Single counter with TTL that resets to zero at fixed time boundaries.
 Simplest implementation with lowest memory usage.
 
+## Sliding Window Counter
+
+The sliding window counter approximates the sliding window with the counts of the
+current and previous fixed windows, the previous one weighted by the part of it
+the sliding window still covers.
+
+	// Sliding window counter keeps two counters per key
+	opts := &strigo.Options{
+		Points:   100000,                // 100000 requests
+		Duration: 3600,                  // per hour
+		Strategy: SlidingWindowCounter,  // Weighted counter algorithm
+	}
+
+Technical: Stores the counts of two windows whatever the number of points, so
+high-volume keys stay small in Redis. Assumes requests were spread evenly over
+the previous window.
+
 # Basic Usage
 
 Create a simple in-memory rate limiter:
@@ -113,6 +133,15 @@ Create a simple in-memory rate limiter:
 		fmt.Printf("❌ Rate limited! Try again in %dms\n", result.MsBeforeNext)
 	}
 
+Window and BlockWindow express the window and block durations as
+time.Duration with millisecond precision, and take precedence over the
+Duration and BlockDuration seconds:
+
+	limiter, err := strigo.New(&strigo.Options{
+		Points: 20,
+		Window: 250 * time.Millisecond, // 20 requests per 250ms
+	})
+
 # Redis Storage
 
 Use Redis for distributed rate limiting:
@@ -176,6 +205,11 @@ Fixed Window:
 - Potential for double-rate at window boundaries
 - Best for simple use cases with clear reset times
 
+Sliding Window Counter:
+- Close to Sliding Window precision without edge bursts
+- Two counters per key regardless of the limit
+- Best for high-volume keys in Redis or Memcached
+
 # Recommended Project Structure
 
 For maintainable applications, organize your rate limiters in separate files:
@@ -270,8 +304,9 @@ main.go - Use in your application:
   - **Redis**: Distributed rate limiting with Redis
   - **Memcached**: Distributed rate limiting with Memcached
 
-All storage backends support both simple counters (for Fixed Window) and complex
-JSON objects (for Token Bucket, Leaky Bucket, and Sliding Window strategies).
+All storage backends execute each strategy as one atomic read-modify-write of the
+key's state. The Redis backend runs the strategies as server-side Lua scripts, so
+limiter instances in different processes sharing a key never over-admit.
 
 # Result Object
 
@@ -292,6 +327,10 @@ The result also provides standard HTTP headers via the Headers() method:
   - X-RateLimit-Reset
   - Retry-After (when limited)
 
+Set Options.HeaderStyle to HeaderStyleIETF for the RateLimit and
+RateLimit-Policy fields of the IETF draft, or HeaderStyleBoth for both sets.
+HeadersFor returns the headers of a given style.
+
 # Additional Operations
 
 Check rate limit status without consuming points:
@@ -306,12 +345,45 @@ Block a key for specific duration:
 
 	err := limiter.Block("user:123", 300) // 300 seconds
 
+Take extra points after a failed attempt, or give points back when a
+request turned out to be cheap:
+
+	result, err := limiter.Penalty("user:123", 2)
+	result, err = limiter.Reward("user:123", 1)
+
+Keep serving requests while the storage backend is down by falling back to
+another limiter, usually a memory one:
+
+	limiter, _ := strigo.New(&strigo.Options{
+		Points:           100,
+		Duration:         60,
+		StoreClient:      redisClient,
+		InsuranceLimiter: memoryLimiter,
+	})
+
+Wait for points instead of rejecting the request, e.g. before calling a
+third-party API shared by many workers:
+
+	if err := limiter.Wait(ctx, "partner-api"); err != nil {
+		return err
+	}
+
+Block keys automatically once they exceed their points, e.g. to slow down
+login brute-force attempts:
+
+	limiter, _ := strigo.New(&strigo.Options{
+		Points:        5,   // 5 attempts
+		Duration:      60,  // per minute
+		BlockDuration: 900, // then blocked for 15 minutes
+	})
+
 # Performance Considerations
 
 - **Token Bucket**: Low memory usage, efficient for most use cases
 - **Leaky Bucket**: Medium memory usage, constant processing overhead
 - **Sliding Window**: High memory usage (stores all timestamps), precise but expensive
 - **Fixed Window**: Lowest memory usage, fastest performance
+- **Sliding Window Counter**: Constant memory usage, close to Sliding Window precision
 
 Choose the strategy based on your precision requirements and performance constraints.
 
@@ -6,6 +6,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/redis/go-redis/v9"
 	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/middleware/fiberlimit"
 )
 
 func main() {
@@ -65,38 +66,9 @@ func main() {
 	}
 	defer uploadLimiter.Close()
 
-	// Middleware function to apply rate limiting
+	// Rate limiting middleware keyed by client IP, consuming the given points per request
 	rateLimitMiddleware := func(limiter *strigo.RateLimiter, points int64) fiber.Handler {
-		return func(c *fiber.Ctx) error {
-			// Use IP address as the key (you could also use user ID, API key, etc.)
-			key := c.IP()
-			
-			result, err := limiter.Consume(key, points)
-			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": "Rate limiter error",
-				})
-			}
-
-			// Add rate limit headers (following HTTP standards)
-			headers := result.Headers()
-			for name, value := range headers {
-				c.Set(name, value)
-			}
-
-			// Check if request is allowed
-			if !result.Allowed {
-				return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-					"error":       "Rate limit exceeded",
-					"retryAfter":  result.MsBeforeNext / 1000,
-					"remaining":   result.RemainingPoints,
-					"limit":       result.TotalHits,
-					"resetTime":   result.MsBeforeNext,
-				})
-			}
-
-			return c.Next()
-		}
+		return fiberlimit.New(limiter, &fiberlimit.Options{Points: points})
 	}
 
 	// Public API endpoint - standard rate limiting
@@ -0,0 +1,74 @@
+package strigo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// insurance tracks whether calls are being served by Options.InsuranceLimiter
+type insurance struct {
+	mu      sync.Mutex
+	active  bool
+	retryAt time.Time
+}
+
+// insured runs primary against the storage backend. When the backend fails
+// and an insurance limiter is configured, fallback runs against it instead
+// and keeps doing so until InsuranceRetryInterval has passed, after which the
+// primary storage is tried again. Cancelled contexts never fail over
+func (rl *RateLimiter) insured(ctx context.Context, primary func() (*Result, error), fallback func(insurance *RateLimiter) (*Result, error)) (*Result, error) {
+	limiter := rl.opts.InsuranceLimiter
+	if limiter == nil {
+		return primary()
+	}
+
+	now := rl.opts.Clock.Now()
+
+	rl.insurance.mu.Lock()
+	waiting := rl.insurance.active && now.Before(rl.insurance.retryAt)
+	rl.insurance.mu.Unlock()
+
+	if waiting {
+		return fallback(limiter)
+	}
+
+	result, err := primary()
+	if err == nil {
+		rl.recover()
+		return result, nil
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+
+	rl.failover(now, err)
+	return fallback(limiter)
+}
+
+// failover switches to the insurance limiter, notifying OnFailover when the
+// primary storage was healthy until now
+func (rl *RateLimiter) failover(now time.Time, err error) {
+	rl.insurance.mu.Lock()
+	wasActive := rl.insurance.active
+	rl.insurance.active = true
+	rl.insurance.retryAt = now.Add(rl.opts.InsuranceRetryInterval)
+	rl.insurance.mu.Unlock()
+
+	if !wasActive && rl.opts.OnFailover != nil {
+		rl.opts.OnFailover(err)
+	}
+}
+
+// recover switches back to the primary storage, notifying OnRecover when the
+// insurance limiter was in use
+func (rl *RateLimiter) recover() {
+	rl.insurance.mu.Lock()
+	wasActive := rl.insurance.active
+	rl.insurance.active = false
+	rl.insurance.mu.Unlock()
+
+	if wasActive && rl.opts.OnRecover != nil {
+		rl.opts.OnRecover()
+	}
+}
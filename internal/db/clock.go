@@ -0,0 +1,17 @@
+package db
+
+import "time"
+
+// Clock tells the current time to the strategies and the memory store
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the Clock backed by time.Now
+var SystemClock Clock = systemClock{}
@@ -0,0 +1,262 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Codec encodes the strategy state stored by the Redis and Memcached backends
+type Codec interface {
+	// Marshal encodes the strategy state v
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into the strategy state v
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec stores strategy state as JSON documents
+var JSONCodec Codec = jsonCodec{}
+
+// BinaryCodec stores strategy state in a compact binary layout: integers and
+// timestamps as varints, floats as 8 bytes. Values other than strategy states
+// are stored as JSON
+var BinaryCodec Codec = binaryCodec{}
+
+// Both built-in codecs read states written by the other one, so switching
+// between them keeps existing limits. State written by the Redis scripts uses
+// a layout of its own and is not read by these codecs
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return unmarshalState(data, v)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	if state, ok := v.(binaryState); ok {
+		return state.appendBinary([]byte{binaryStateMagic, binaryStateVersion}), nil
+	}
+	return json.Marshal(v)
+}
+
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	return unmarshalState(data, v)
+}
+
+// Binary states start with a byte JSON documents cannot start with, followed
+// by the layout version. Version 2 is the layout written by the Redis scripts,
+// see stateCodec, which encodes numbers and timestamps differently
+const (
+	binaryStateMagic        = 0xb7
+	binaryStateVersion      = 1
+	redisBinaryStateVersion = 2
+)
+
+// errBinaryState reports binary state that is truncated or of another version
+var errBinaryState = errors.New("malformed binary state")
+
+// unmarshalState decodes data, binary or JSON, into v
+func unmarshalState(data []byte, v interface{}) error {
+	if len(data) == 0 || data[0] != binaryStateMagic {
+		return json.Unmarshal(data, v)
+	}
+
+	state, ok := v.(binaryState)
+	if !ok {
+		return fmt.Errorf("cannot decode binary state into %T", v)
+	}
+	if len(data) < 2 {
+		return errBinaryState
+	}
+	switch data[1] {
+	case binaryStateVersion:
+	case redisBinaryStateVersion:
+		return errors.New("binary state written by the Redis scripts can only be read by Redis")
+	default:
+		return fmt.Errorf("unsupported binary state version %d", data[1])
+	}
+
+	r := binaryReader{data: data[2:]}
+	state.readBinary(&r)
+	if r.err == nil && len(r.data) > 0 {
+		r.err = errBinaryState
+	}
+	return r.err
+}
+
+// binaryState is implemented by the strategy states encoded by BinaryCodec
+type binaryState interface {
+	appendBinary(b []byte) []byte
+	readBinary(r *binaryReader)
+}
+
+func (d *TokenBucketData) appendBinary(b []byte) []byte {
+	b = appendFloat(b, d.Tokens)
+	b = appendTime(b, d.LastRefill)
+	b = binary.AppendVarint(b, d.Capacity)
+	return appendFloat(b, d.RefillRate)
+}
+
+func (d *TokenBucketData) readBinary(r *binaryReader) {
+	d.Tokens = r.float()
+	d.LastRefill = r.time()
+	d.Capacity = r.varint()
+	d.RefillRate = r.float()
+}
+
+func (d *LeakyBucketData) appendBinary(b []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(d.Queue)))
+	var prev time.Time
+	for _, req := range d.Queue {
+		b = appendTimeDelta(b, req.Timestamp, prev)
+		b = binary.AppendVarint(b, req.Points)
+		prev = req.Timestamp
+	}
+	b = appendTime(b, d.LastDrain)
+	return appendFloat(b, d.DrainRate)
+}
+
+func (d *LeakyBucketData) readBinary(r *binaryReader) {
+	d.Queue = make([]QueuedRequest, r.length())
+	var prev time.Time
+	for i := range d.Queue {
+		d.Queue[i].Timestamp = r.timeDelta(prev)
+		d.Queue[i].Points = r.varint()
+		prev = d.Queue[i].Timestamp
+	}
+	d.LastDrain = r.time()
+	d.DrainRate = r.float()
+}
+
+func (d *SlidingWindowData) appendBinary(b []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(d.Requests)))
+	var prev time.Time
+	for _, ts := range d.Requests {
+		b = appendTimeDelta(b, ts, prev)
+		prev = ts
+	}
+	return b
+}
+
+func (d *SlidingWindowData) readBinary(r *binaryReader) {
+	d.Requests = make([]time.Time, r.length())
+	var prev time.Time
+	for i := range d.Requests {
+		d.Requests[i] = r.timeDelta(prev)
+		prev = d.Requests[i]
+	}
+}
+
+func (d *FixedWindowData) appendBinary(b []byte) []byte {
+	b = binary.AppendVarint(b, d.Count)
+	return appendTime(b, d.WindowStart)
+}
+
+func (d *FixedWindowData) readBinary(r *binaryReader) {
+	d.Count = r.varint()
+	d.WindowStart = r.time()
+}
+
+func (d *SlidingWindowCounterData) appendBinary(b []byte) []byte {
+	b = binary.AppendVarint(b, d.Count)
+	b = binary.AppendVarint(b, d.PrevCount)
+	return appendTime(b, d.WindowStart)
+}
+
+func (d *SlidingWindowCounterData) readBinary(r *binaryReader) {
+	d.Count = r.varint()
+	d.PrevCount = r.varint()
+	d.WindowStart = r.time()
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+}
+
+// appendTime appends t as unix nanoseconds, or 0 for the zero time
+func appendTime(b []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return binary.AppendVarint(b, 0)
+	}
+	return binary.AppendVarint(b, t.UnixNano())
+}
+
+// appendTimeDelta appends t relative to prev, the previous time in a list,
+// which keeps sorted timestamps down to a few bytes each
+func appendTimeDelta(b []byte, t, prev time.Time) []byte {
+	if prev.IsZero() {
+		return appendTime(b, t)
+	}
+	return binary.AppendVarint(b, int64(t.Sub(prev)))
+}
+
+// binaryReader decodes the fields of a binary state, remembering the first error
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errBinaryState
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// length reads the length of a list, which cannot exceed the remaining bytes
+func (r *binaryReader) length() int {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 || v > uint64(len(r.data)) {
+		r.err = errBinaryState
+		return 0
+	}
+	r.data = r.data[n:]
+	return int(v)
+}
+
+func (r *binaryReader) float() float64 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) < 8 {
+		r.err = errBinaryState
+		return 0
+	}
+	f := math.Float64frombits(binary.LittleEndian.Uint64(r.data))
+	r.data = r.data[8:]
+	return f
+}
+
+func (r *binaryReader) time() time.Time {
+	ns := r.varint()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns).UTC()
+}
+
+func (r *binaryReader) timeDelta(prev time.Time) time.Time {
+	if prev.IsZero() {
+		return r.time()
+	}
+	return prev.Add(time.Duration(r.varint()))
+}
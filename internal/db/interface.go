@@ -5,6 +5,28 @@ import (
 	"time"
 )
 
+// Operation kinds understood by Storage.Atomic
+const (
+	// OpConsume consumes points from the strategy state
+	OpConsume = "consume"
+
+	// OpGet reads the strategy state without modifying it
+	OpGet = "get"
+
+	// OpPenalty consumes points whether or not they are available, up to the limit
+	OpPenalty = "penalty"
+
+	// OpReward gives consumed points back
+	OpReward = "reward"
+
+	// OpReserve takes points ahead of time, reporting when they may be used
+	OpReserve = "reserve"
+
+	// OpCancel gives back the points of the consume identified by op.Slot.
+	// Only the leaky bucket, fixed window and sliding window counter implement it
+	OpCancel = "cancel"
+)
+
 // Storage defines the interface for rate limiter storage backends
 type Storage interface {
 	// Increment increments the counter for the given key by the specified amount and returns the new count
@@ -13,7 +35,8 @@ type Storage interface {
 	// Get returns the current count for the given key
 	Get(ctx context.Context, key string) (int64, error)
 
-	// Reset resets the counter for the given key
+	// Reset resets the counter for the given key. Resetting a key that does
+	// not exist is not an error
 	Reset(ctx context.Context, key string) error
 
 	// SetJSON stores a JSON-serializable object with expiry
@@ -22,6 +45,147 @@ type Storage interface {
 	// GetJSON retrieves and deserializes a JSON object
 	GetJSON(ctx context.Context, key string, dest interface{}) error
 
+	// Atomic executes a strategy operation as a single atomic read-modify-write.
+	// Backends with server-side scripting run the strategy natively, others
+	// load op.State, call op.Apply and persist the state it returns.
+	//
+	// Before touching the state, implementations must deny the operation while
+	// the JSON timestamp stored at op.BlockKey is in the future (see
+	// AtomicOp.BlockedResult), and after a denied consume they must store the
+	// timestamp returned by AtomicOp.BlockOnDenial at op.BlockKey
+	Atomic(ctx context.Context, op *AtomicOp) (*AtomicResult, error)
+
 	// Close closes the storage connection
 	Close() error
 }
+
+// BatchStorage is implemented by backends that can execute several atomic
+// operations in one round trip
+type BatchStorage interface {
+	// AtomicBatch executes ops in order with the semantics of calling Atomic
+	// for each of them, and returns their results in the same order
+	AtomicBatch(ctx context.Context, ops []*AtomicOp) ([]*AtomicResult, error)
+}
+
+// AtomicBatch executes ops through storage's AtomicBatch when it implements
+// BatchStorage, and one Atomic call at a time otherwise
+func AtomicBatch(ctx context.Context, storage Storage, ops []*AtomicOp) ([]*AtomicResult, error) {
+	if batch, ok := storage.(BatchStorage); ok {
+		return batch.AtomicBatch(ctx, ops)
+	}
+
+	results := make([]*AtomicResult, len(ops))
+	for i, op := range ops {
+		result, err := storage.Atomic(ctx, op)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// AtomicOp describes a strategy-aware operation on a single state key
+type AtomicOp struct {
+	// Kind is the operation to perform (OpConsume, OpGet, OpPenalty, OpReward, OpReserve, OpCancel)
+	Kind string
+
+	// Strategy names the rate limiting algorithm that owns the state
+	// (e.g. "token_bucket"), used by backends that execute strategies natively
+	Strategy string
+
+	// Key is the storage key holding the strategy state
+	Key string
+
+	// BlockKey is the storage key marking the limiter key as blocked. While it
+	// holds a unix millisecond timestamp after Now the operation is denied
+	// without touching the strategy state
+	BlockKey string
+
+	// Points is the number of points the operation applies
+	Points int64
+
+	// Limit is the maximum number of points allowed per Window
+	Limit int64
+
+	// Window is the strategy time window
+	Window time.Duration
+
+	// TTL is the expiry applied to the stored state when it is saved
+	TTL time.Duration
+
+	// BlockDuration, when positive, blocks the key through BlockKey for this
+	// long as soon as a consume operation is denied
+	BlockDuration time.Duration
+
+	// Now is the time the operation is evaluated at
+	Now time.Time
+
+	// Slot identifies the consume undone by OpCancel: the leaky bucket request
+	// queued to be processed within the millisecond up to Slot, or for the
+	// window strategies the time of the consume
+	Slot time.Time
+
+	// State points to the Go representation of the strategy state
+	State interface{}
+
+	// Apply runs the strategy against State; exists reports whether state was
+	// found in storage. It returns the outcome and whether State must be saved
+	Apply func(exists bool) (result AtomicResult, save bool)
+
+	// result holds the outcome returned by backends that can point into op
+	result AtomicResult
+}
+
+// AtomicResult is the outcome of an AtomicOp
+type AtomicResult struct {
+	// Exists reports whether strategy state was stored before the operation
+	Exists bool
+
+	// Allowed reports whether the operation was admitted
+	Allowed bool
+
+	// RemainingPoints is the number of points left after the operation
+	RemainingPoints int64
+
+	// ConsumedPoints is the number of points consumed in the current duration
+	ConsumedPoints int64
+
+	// MsBeforeNext is the number of milliseconds before the next action can be done
+	MsBeforeNext int64
+
+	// IsFirstInDuration reports whether the action is first in the current duration
+	IsFirstInDuration bool
+}
+
+// BlockedResult returns the result reported for op on a key blocked until the
+// given unix millisecond timestamp, and whether that block is still active.
+// Penalty and reward operations change the state of blocked keys as well
+func (op *AtomicOp) BlockedResult(blockedUntil int64) (*AtomicResult, bool) {
+	if op.Kind != OpConsume && op.Kind != OpGet && op.Kind != OpReserve {
+		return nil, false
+	}
+
+	msBeforeNext := blockedUntil - op.Now.UnixMilli()
+	if msBeforeNext <= 0 {
+		return nil, false
+	}
+
+	return &AtomicResult{
+		Exists:         true,
+		ConsumedPoints: op.Limit,
+		MsBeforeNext:   msBeforeNext,
+	}, true
+}
+
+// BlockOnDenial reports whether result, the outcome of op, must block the key
+// and returns the unix millisecond timestamp the block ends at. The result is
+// updated to report the block
+func (op *AtomicOp) BlockOnDenial(result *AtomicResult) (int64, bool) {
+	if op.Kind != OpConsume || result.Allowed || op.BlockDuration <= 0 || op.BlockKey == "" {
+		return 0, false
+	}
+
+	result.MsBeforeNext = op.BlockDuration.Milliseconds()
+	return op.Now.Add(op.BlockDuration).UnixMilli(), true
+}
@@ -3,14 +3,29 @@ package db
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"reflect"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 )
 
+// maxCASAttempts bounds how often an update of a key that other processes
+// keep writing is retried, waiting up to maxCASBackoff between attempts
+const (
+	maxCASAttempts = 20
+	maxCASBackoff  = 50 * time.Millisecond
+)
+
+// errCASConflict reports that a state item was written or deleted by another
+// process between the get and the compare-and-swap of an operation
+var errCASConflict = errors.New("memcached item changed concurrently")
+
 type MemcachedClient struct {
 	client *memcache.Client
+	codec  Codec // Encodes the strategy state
 }
 
 func NewMemcachedClient(address string) (*MemcachedClient, error) {
@@ -21,32 +36,48 @@ func NewMemcachedClient(address string) (*MemcachedClient, error) {
 
 	return &MemcachedClient{
 		client: client,
+		codec:  JSONCodec,
 	}, nil
 }
 
 func (m *MemcachedClient) Increment(ctx context.Context, key string, amount int64, expiry time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	// Memcached increment accepts uint64, need to convert safely
 	if amount < 0 {
 		return 0, fmt.Errorf("memcached increment amount cannot be negative: %d", amount)
 	}
 	
-	value, err := m.client.Increment(key, uint64(amount))
-	if err == memcache.ErrCacheMiss {
-		// Key doesn't exist, create it with the initial amount
-		err = m.client.Set(&memcache.Item{
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		value, err := m.client.Increment(key, uint64(amount))
+		if !errors.Is(err, memcache.ErrCacheMiss) {
+			return int64(value), err
+		}
+
+		// Key doesn't exist, create it with the initial amount unless a
+		// concurrent caller did so first
+		err = m.client.Add(&memcache.Item{
 			Key:        key,
 			Value:      []byte(fmt.Sprintf("%d", amount)),
-			Expiration: int32(expiry.Seconds()),
+			Expiration: expirationSeconds(expiry),
 		})
-		if err != nil {
-			return 0, err
+		if !errors.Is(err, memcache.ErrNotStored) {
+			if err != nil {
+				return 0, err
+			}
+			return amount, nil
 		}
-		return amount, nil
 	}
-	return int64(value), err
+	return 0, fmt.Errorf("failed to increment %s: too many concurrent updates", key)
 }
 
 func (m *MemcachedClient) Get(ctx context.Context, key string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	item, err := m.client.Get(key)
 	if err == memcache.ErrCacheMiss {
 		return 0, nil
@@ -61,11 +92,23 @@ func (m *MemcachedClient) Get(ctx context.Context, key string) (int64, error) {
 }
 
 func (m *MemcachedClient) Reset(ctx context.Context, key string) error {
-	return m.client.Delete(key)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Deleting a missing key is not an error, as with the other backends
+	if err := m.client.Delete(key); err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return err
+	}
+	return nil
 }
 
 // SetJSON stores a JSON-serializable object with expiry
 func (m *MemcachedClient) SetJSON(ctx context.Context, key string, value interface{}, expiry time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	data, err := json.Marshal(value)
 	if err != nil {
 		return err
@@ -74,12 +117,16 @@ func (m *MemcachedClient) SetJSON(ctx context.Context, key string, value interfa
 	return m.client.Set(&memcache.Item{
 		Key:        key,
 		Value:      data,
-		Expiration: int32(expiry.Seconds()),
+		Expiration: expirationSeconds(expiry),
 	})
 }
 
 // GetJSON retrieves and deserializes a JSON object
 func (m *MemcachedClient) GetJSON(ctx context.Context, key string, dest interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	item, err := m.client.Get(key)
 	if err == memcache.ErrCacheMiss {
 		return nil // Key doesn't exist, return empty
@@ -91,6 +138,185 @@ func (m *MemcachedClient) GetJSON(ctx context.Context, key string, dest interfac
 	return json.Unmarshal(item.Value, dest)
 }
 
+// Atomic runs the strategy in Go between a get and a compare-and-swap of the
+// state item, creating new items with add. Memcached has no server-side
+// scripting, so the operation starts over when another process wrote the
+// item in between
+func (m *MemcachedClient) Atomic(ctx context.Context, op *AtomicOp) (*AtomicResult, error) {
+	// Fetch the block and state items in one round trip
+	keys := []string{op.Key}
+	if op.BlockKey != "" {
+		keys = append(keys, op.BlockKey)
+	}
+
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		if attempt > 0 {
+			if err := casBackoff(ctx, attempt); err != nil {
+				return nil, err
+			}
+		}
+
+		// The memcache client has no context support, so honour cancellation between calls
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		items, err := m.client.GetMulti(keys)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := m.apply(ctx, op, items)
+		if !errors.Is(err, errCASConflict) {
+			return result, err
+		}
+	}
+	return nil, fmt.Errorf("failed to update %s: too many concurrent updates", op.Key)
+}
+
+// AtomicBatch fetches the block and state items of all ops with a single
+// GetMulti, then runs the strategies in Go and saves the states one by one.
+// Ops on a key already updated earlier in the batch, or updated concurrently
+// by another process, reload it through Atomic
+func (m *MemcachedClient) AtomicBatch(ctx context.Context, ops []*AtomicOp) ([]*AtomicResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, 2*len(ops))
+	for _, op := range ops {
+		if op.BlockKey != "" {
+			keys = append(keys, op.BlockKey)
+		}
+		keys = append(keys, op.Key)
+	}
+
+	items, err := m.client.GetMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*AtomicResult, len(ops))
+	seen := make(map[string]bool, len(ops))
+	for i, op := range ops {
+		if seen[op.Key] || seen[op.BlockKey] {
+			if results[i], err = m.Atomic(ctx, op); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		seen[op.Key] = true
+		if op.BlockKey != "" {
+			seen[op.BlockKey] = true
+		}
+
+		results[i], err = m.apply(ctx, op, items)
+		if errors.Is(err, errCASConflict) {
+			results[i], err = m.Atomic(ctx, op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// apply runs op against its block and state items, fetched beforehand. It
+// returns errCASConflict when the state item changed since it was fetched
+func (m *MemcachedClient) apply(ctx context.Context, op *AtomicOp, items map[string]*memcache.Item) (*AtomicResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if item, ok := items[op.BlockKey]; ok && op.BlockKey != "" {
+		var blockedUntil int64
+		if err := json.Unmarshal(item.Value, &blockedUntil); err != nil {
+			return nil, err
+		}
+		if result, blocked := op.BlockedResult(blockedUntil); blocked {
+			return result, nil
+		}
+	}
+
+	// Clear the state left by a previous attempt
+	state := reflect.ValueOf(op.State).Elem()
+	state.Set(reflect.Zero(state.Type()))
+
+	item, exists := items[op.Key]
+	if exists {
+		if err := m.codec.Unmarshal(item.Value, op.State); err != nil {
+			return nil, err
+		}
+	}
+
+	result, save := op.Apply(exists)
+	if save {
+		if err := m.saveState(op, item); err != nil {
+			return nil, err
+		}
+	}
+
+	if blockedUntil, block := op.BlockOnDenial(&result); block {
+		if err := m.SetJSON(ctx, op.BlockKey, blockedUntil, op.BlockDuration); err != nil {
+			return nil, err
+		}
+	}
+
+	return &result, nil
+}
+
+// saveState stores the state of op, swapping item when it was fetched and
+// adding a new item otherwise
+func (m *MemcachedClient) saveState(op *AtomicOp, item *memcache.Item) error {
+	data, err := m.codec.Marshal(op.State)
+	if err != nil {
+		return err
+	}
+
+	if item == nil {
+		err = m.client.Add(&memcache.Item{
+			Key:        op.Key,
+			Value:      data,
+			Expiration: expirationSeconds(op.TTL),
+		})
+	} else {
+		item.Value = data
+		item.Expiration = expirationSeconds(op.TTL)
+		err = m.client.CompareAndSwap(item)
+	}
+
+	// Another process added, swapped or deleted the item first
+	if errors.Is(err, memcache.ErrNotStored) || errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrCacheMiss) {
+		return errCASConflict
+	}
+	return err
+}
+
+// casBackoff waits a random time growing with attempt before an operation
+// that lost a compare-and-swap is retried, so competing writers spread out
+func casBackoff(ctx context.Context, attempt int) error {
+	limit := min(time.Millisecond<<attempt, maxCASBackoff)
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(limit))))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// expirationSeconds converts expiry to Memcached's whole-second expiration,
+// rounding up so that sub-second expiries do not become 0 (never expire).
+// Strategies compare stored timestamps, so the extra lifetime is harmless
+func expirationSeconds(expiry time.Duration) int32 {
+	if expiry <= 0 {
+		return 0
+	}
+	return int32((expiry + time.Second - 1) / time.Second)
+}
+
 func (m *MemcachedClient) Close() error {
 	// Memcache client doesn't have a close method
 	return nil
@@ -98,12 +324,22 @@ func (m *MemcachedClient) Close() error {
 
 // NewMemcachedStorageFromClient creates a Memcached storage instance from an existing Memcached client
 func NewMemcachedStorageFromClient(client interface{}) (Storage, error) {
+	return NewMemcachedStorageWithCodec(client, JSONCodec)
+}
+
+// NewMemcachedStorageWithCodec creates a Memcached storage instance from an
+// existing Memcached client, encoding the strategy state with codec
+func NewMemcachedStorageWithCodec(client interface{}, codec Codec) (Storage, error) {
 	memcachedClient, ok := client.(*memcache.Client)
 	if !ok {
 		return nil, fmt.Errorf("invalid client type: expected *memcache.Client, got %T", client)
 	}
-	
+	if codec == nil {
+		codec = JSONCodec
+	}
+
 	return &MemcachedClient{
 		client: memcachedClient,
+		codec:  codec,
 	}, nil
 }
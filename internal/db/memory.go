@@ -1,133 +1,436 @@
 package db
 
 import (
+	"container/list"
 	"context"
 	"encoding/json"
+	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// memoryShards is the number of shards MemoryStorage spreads its keys
+	// over. It must be a power of two
+	memoryShards = 64
+
+	// minShardKeys is the number of keys a shard of a MemoryStorage limited
+	// by MaxKeys holds at least. Smaller limits use fewer shards
+	minShardKeys = 64
+
+	// defaultCleanupInterval is how often expired keys are removed by default
+	defaultCleanupInterval = time.Minute
+)
+
+// memoryShard holds the keys hashing to one shard under its own lock
+type memoryShard struct {
+	mu     sync.RWMutex
+	data   map[string]int64
+	values map[string]interface{} // JSON encoded by SetJSON, or native strategy states and blocks
+	expiry map[string]time.Time
+
+	// Keys from most to least recently used, only kept when maxKeys is set
+	maxKeys int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// touch marks key as the most recently used key of the shard. When key is
+// new and the shard is full, the least recently used key is evicted first.
+// The caller must hold the write lock
+func (s *memoryShard) touch(key string) {
+	if s.order == nil {
+		return
+	}
+
+	if elem, ok := s.entries[key]; ok {
+		s.order.MoveToFront(elem)
+		return
+	}
+
+	if s.order.Len() >= s.maxKeys {
+		s.remove(s.order.Back().Value.(string))
+	}
+	s.entries[key] = s.order.PushFront(key)
+}
+
+// remove deletes key from the shard. The caller must hold the write lock
+func (s *memoryShard) remove(key string) {
+	delete(s.data, key)
+	delete(s.values, key)
+	delete(s.expiry, key)
+
+	if elem, ok := s.entries[key]; ok {
+		s.order.Remove(elem)
+		delete(s.entries, key)
+	}
+}
+
+// MemoryOptions configures a MemoryStorage
+type MemoryOptions struct {
+	// MaxKeys bounds the number of keys held, evicting the least recently
+	// used keys of a shard to make room for new ones
+	// Default: 0 (unlimited)
+	MaxKeys int
+
+	// CleanupInterval is how often expired keys are removed
+	// Default: one minute
+	CleanupInterval time.Duration
+
+	// Clock expires the keys
+	// Default: SystemClock
+	Clock Clock
+}
+
 // MemoryStorage provides an in-memory implementation of the Storage interface
 // Useful for testing or when no external storage backend is available
+//
+// Strategy states are kept as Go values rather than JSON, so Atomic applies
+// the token bucket, fixed window and sliding window counter without allocating.
+// Keys are spread over shards with their own lock, so operations on unrelated
+// keys do not contend with each other. Keys sharing a Redis Cluster hash tag
+// land in the same shard, which lets Atomic lock a limiter key's state and
+// block keys together
+//
+// Expired keys are removed by a janitor goroutine, which runs until Close
 type MemoryStorage struct {
-	data   map[string]int64
-	jsonData map[string][]byte
-	expiry map[string]time.Time
-	mu     sync.RWMutex
+	shards []memoryShard
+	clock  Clock
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewMemoryStorage creates a new in-memory storage instance
 func NewMemoryStorage() *MemoryStorage {
+	return NewMemoryStorageWithOptions(MemoryOptions{})
+}
+
+// NewMemoryStorageWithClock creates an in-memory storage instance that
+// expires keys according to clock
+func NewMemoryStorageWithClock(clock Clock) *MemoryStorage {
+	return NewMemoryStorageWithOptions(MemoryOptions{Clock: clock})
+}
+
+// NewMemoryStorageWithOptions creates an in-memory storage instance
+// configured by opts
+func NewMemoryStorageWithOptions(opts MemoryOptions) *MemoryStorage {
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = defaultCleanupInterval
+	}
+
+	// Every shard of a bounded storage holds the same share of MaxKeys
+	shards := memoryShards
+	if opts.MaxKeys > 0 {
+		for shards > 1 && opts.MaxKeys/shards < minShardKeys {
+			shards /= 2
+		}
+	}
+
 	storage := &MemoryStorage{
-		data:     make(map[string]int64),
-		jsonData: make(map[string][]byte),
-		expiry:   make(map[string]time.Time),
+		shards: make([]memoryShard, shards),
+		clock:  opts.Clock,
+		done:   make(chan struct{}),
+	}
+	for i := range storage.shards {
+		shard := &storage.shards[i]
+		shard.data = make(map[string]int64)
+		shard.values = make(map[string]interface{})
+		shard.expiry = make(map[string]time.Time)
+
+		if opts.MaxKeys > 0 {
+			shard.maxKeys = opts.MaxKeys / shards
+			shard.order = list.New()
+			shard.entries = make(map[string]*list.Element)
+		}
 	}
-	
+
 	// Start cleanup goroutine
-	go storage.cleanup()
-	
+	go storage.cleanup(opts.CleanupInterval)
+
 	return storage
 }
 
+// shard returns the shard holding key
+func (m *MemoryStorage) shard(key string) *memoryShard {
+	return &m.shards[m.shardIndex(key)]
+}
+
+// shardIndex returns the position in m.shards of the shard holding key
+func (m *MemoryStorage) shardIndex(key string) uint32 {
+	return shardHash(hashTag(key)) & uint32(len(m.shards)-1)
+}
+
+// hashTag returns the part of key between the first '{' and the next '}',
+// like Redis Cluster does, or key itself when it has no non-empty hash tag
+func hashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
+// shardHash is the 32-bit FNV-1a hash of s
+func shardHash(s string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		hash ^= uint32(s[i])
+		hash *= 16777619
+	}
+	return hash
+}
+
 // Increment increments the counter for the given key by the specified amount and returns the new count
 func (m *MemoryStorage) Increment(ctx context.Context, key string, amount int64, expiry time.Duration) (int64, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
 	// Check if key has expired
-	if exp, exists := m.expiry[key]; exists && time.Now().After(exp) {
-		delete(m.data, key)
-		delete(m.expiry, key)
+	if exp, exists := shard.expiry[key]; exists && m.clock.Now().After(exp) {
+		delete(shard.data, key)
+		delete(shard.expiry, key)
 	}
-	
+
 	// Increment counter by the specified amount
-	count := m.data[key] + amount
-	m.data[key] = count
-	m.expiry[key] = time.Now().Add(expiry)
-	
+	shard.touch(key)
+	count := shard.data[key] + amount
+	shard.data[key] = count
+	shard.expiry[key] = m.clock.Now().Add(expiry)
+
 	return count, nil
 }
 
 // Get returns the current count for the given key
 func (m *MemoryStorage) Get(ctx context.Context, key string) (int64, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	
+	shard := m.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
 	// Check if key has expired
-	if exp, exists := m.expiry[key]; exists && time.Now().After(exp) {
+	if exp, exists := shard.expiry[key]; exists && m.clock.Now().After(exp) {
 		return 0, nil
 	}
-	
-	return m.data[key], nil
+
+	return shard.data[key], nil
 }
 
 // Reset resets the counter for the given key
 func (m *MemoryStorage) Reset(ctx context.Context, key string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	
-	delete(m.data, key)
-	delete(m.jsonData, key)
-	delete(m.expiry, key)
-	
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.remove(key)
+
 	return nil
 }
 
 // SetJSON stores a JSON-serializable object with expiry
 func (m *MemoryStorage) SetJSON(ctx context.Context, key string, value interface{}, expiry time.Duration) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	
 	data, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
-	
-	m.jsonData[key] = data
-	m.expiry[key] = time.Now().Add(expiry)
-	
+
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.touch(key)
+	shard.values[key] = data
+	shard.expiry[key] = m.clock.Now().Add(expiry)
+
 	return nil
 }
 
 // GetJSON retrieves and deserializes a JSON object
 func (m *MemoryStorage) GetJSON(ctx context.Context, key string, dest interface{}) error {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	
-	// Check if key has expired
-	if exp, exists := m.expiry[key]; exists && time.Now().After(exp) {
-		return nil // Key expired, return empty
+	shard := m.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	_, err := m.load(shard, key, dest)
+	return err
+}
+
+// Atomic executes op under the lock of the shard holding op.Key, so the
+// strategy's read-modify-write cannot interleave with other operations on
+// the key in the process
+func (m *MemoryStorage) Atomic(ctx context.Context, op *AtomicOp) (*AtomicResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	index := m.shardIndex(op.Key)
+	blockIndex := index
+	if op.BlockKey != "" {
+		blockIndex = m.shardIndex(op.BlockKey)
+	}
+	shard, blockShard := &m.shards[index], &m.shards[blockIndex]
+
+	// Keys built by the limiter share a hash tag and thus a shard; keys that
+	// do not are locked in shard order to avoid deadlocks
+	first, second := shard, blockShard
+	if index > blockIndex {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	if second != first {
+		second.mu.Lock()
+		defer second.mu.Unlock()
+	}
+
+	if op.BlockKey != "" {
+		blockedUntil, err := m.loadBlock(blockShard, op.BlockKey)
+		if err != nil {
+			return nil, err
+		}
+		if result, blocked := op.BlockedResult(blockedUntil); blocked {
+			// Requests for a blocked key keep it and its block from being
+			// evicted, which would lift the block early
+			blockShard.touch(op.BlockKey)
+			if _, exists := shard.values[op.Key]; exists {
+				shard.touch(op.Key)
+			}
+			return result, nil
+		}
+	}
+
+	exists, err := m.load(shard, op.Key, op.State)
+	if err != nil {
+		return nil, err
+	}
+
+	// The result is kept in op so returning it does not allocate
+	var save bool
+	op.result, save = op.Apply(exists)
+	if exists || save {
+		shard.touch(op.Key)
+	}
+	if save {
+		if state, ok := op.State.(nativeState); ok {
+			shard.values[op.Key] = state.save(shard.values[op.Key])
+		} else {
+			data, err := json.Marshal(op.State)
+			if err != nil {
+				return nil, err
+			}
+			shard.values[op.Key] = data
+		}
+		shard.expiry[op.Key] = m.clock.Now().Add(op.TTL)
+	}
+
+	if blockedUntil, block := op.BlockOnDenial(&op.result); block {
+		blockShard.touch(op.BlockKey)
+		blockShard.values[op.BlockKey] = blockedUntil
+		blockShard.expiry[op.BlockKey] = m.clock.Now().Add(op.BlockDuration)
 	}
-	
-	data, exists := m.jsonData[key]
+
+	return &op.result, nil
+}
+
+// load copies the unexpired value of key into dest and reports whether it
+// was found. Native values are copied directly when dest has the same type and
+// converted through JSON otherwise. The caller must hold the lock of shard
+func (m *MemoryStorage) load(shard *memoryShard, key string, dest interface{}) (bool, error) {
+	if exp, exists := shard.expiry[key]; exists && m.clock.Now().After(exp) {
+		return false, nil
+	}
+
+	value, exists := shard.values[key]
 	if !exists {
-		return nil // Key doesn't exist, return empty
+		return false, nil
+	}
+
+	if data, ok := value.([]byte); ok {
+		return true, json.Unmarshal(data, dest)
+	}
+	if state, ok := dest.(nativeState); ok && state.load(value) {
+		return true, nil
 	}
-	
-	return json.Unmarshal(data, dest)
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return true, err
+	}
+	return true, json.Unmarshal(data, dest)
 }
 
-// Close closes the storage (no-op for memory storage)
+// loadBlock returns the unix millisecond timestamp key is blocked until, or
+// zero when it is not blocked. The caller must hold the lock of shard
+func (m *MemoryStorage) loadBlock(shard *memoryShard, key string) (int64, error) {
+	if exp, exists := shard.expiry[key]; exists && m.clock.Now().After(exp) {
+		return 0, nil
+	}
+
+	value, exists := shard.values[key]
+	if !exists {
+		return 0, nil
+	}
+	if blockedUntil, ok := value.(int64); ok {
+		return blockedUntil, nil
+	}
+
+	// Blocks stored with SetJSON
+	var blockedUntil int64
+	_, err := m.load(shard, key, &blockedUntil)
+	return blockedUntil, err
+}
+
+// Len returns the number of keys held, including expired keys the janitor
+// has not removed yet
+func (m *MemoryStorage) Len() int {
+	count := 0
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mu.RLock()
+		count += len(shard.expiry)
+		shard.mu.RUnlock()
+	}
+	return count
+}
+
+// Close stops the janitor goroutine. The stored keys stay readable
 func (m *MemoryStorage) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
 	return nil
 }
 
-// cleanup removes expired keys periodically
-func (m *MemoryStorage) cleanup() {
-	ticker := time.NewTicker(time.Minute)
+// cleanup removes expired keys every interval until the storage is closed,
+// locking one shard at a time
+func (m *MemoryStorage) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
-	
-	for range ticker.C {
-		m.mu.Lock()
-		now := time.Now()
-		for key, exp := range m.expiry {
-			if now.After(exp) {
-				delete(m.data, key)
-				delete(m.jsonData, key)
-				delete(m.expiry, key)
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+
+		for i := range m.shards {
+			shard := &m.shards[i]
+			shard.mu.Lock()
+			now := m.clock.Now()
+			for key, exp := range shard.expiry {
+				if now.After(exp) {
+					shard.remove(key)
+				}
 			}
+			shard.mu.Unlock()
 		}
-		m.mu.Unlock()
 	}
-} 
\ No newline at end of file
+}
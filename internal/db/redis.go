@@ -9,8 +9,12 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// RedisClient stores rate limiter state in Redis. The client may be a single
+// node, Sentinel failover, Ring or Cluster client; strategy scripts touch only
+// keys sharing the limiter key's hash tag, so they run within one cluster slot
 type RedisClient struct {
-	client *redis.Client
+	client redis.UniversalClient
+	codec  Codec // JSONCodec or BinaryCodec, implemented by the scripts
 }
 
 func NewRedisClient(address string) (*RedisClient, error) {
@@ -26,6 +30,7 @@ func NewRedisClient(address string) (*RedisClient, error) {
 
 	return &RedisClient{
 		client: client,
+		codec:  JSONCodec,
 	}, nil
 }
 
@@ -77,18 +82,136 @@ func (r *RedisClient) GetJSON(ctx context.Context, key string, dest interface{})
 	return json.Unmarshal([]byte(val), dest)
 }
 
+// Atomic executes the strategy's Lua script, so the whole read-modify-write
+// runs on the Redis server and is atomic across all processes sharing the key
+func (r *RedisClient) Atomic(ctx context.Context, op *AtomicOp) (*AtomicResult, error) {
+	script, ok := strategyScripts[op.Strategy]
+	if !ok {
+		return nil, fmt.Errorf("no atomic script for strategy: %s", op.Strategy)
+	}
+
+	keys, args := r.scriptArgs(op)
+	vals, err := script.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseScriptReply(vals)
+}
+
+// AtomicBatch executes the strategy scripts of all ops in one pipeline. Ops
+// whose script is not cached by their Redis node yet are sent again with the
+// script source in a second pipeline
+func (r *RedisClient) AtomicBatch(ctx context.Context, ops []*AtomicOp) ([]*AtomicResult, error) {
+	scripts := make([]*redis.Script, len(ops))
+	for i, op := range ops {
+		script, ok := strategyScripts[op.Strategy]
+		if !ok {
+			return nil, fmt.Errorf("no atomic script for strategy: %s", op.Strategy)
+		}
+		scripts[i] = script
+	}
+
+	cmds := make([]*redis.Cmd, len(ops))
+	pipe := r.client.Pipeline()
+	for i, op := range ops {
+		keys, args := r.scriptArgs(op)
+		cmds[i] = scripts[i].EvalSha(ctx, pipe, keys, args...)
+	}
+	// Errors are checked per command below
+	_, _ = pipe.Exec(ctx)
+
+	var retry []int
+	for i, cmd := range cmds {
+		if err := cmd.Err(); err != nil && redis.HasErrorPrefix(err, "NOSCRIPT") {
+			retry = append(retry, i)
+		}
+	}
+	if len(retry) > 0 {
+		pipe := r.client.Pipeline()
+		for _, i := range retry {
+			keys, args := r.scriptArgs(ops[i])
+			cmds[i] = scripts[i].Eval(ctx, pipe, keys, args...)
+		}
+		_, _ = pipe.Exec(ctx)
+	}
+
+	results := make([]*AtomicResult, len(ops))
+	for i, cmd := range cmds {
+		vals, err := cmd.Int64Slice()
+		if err != nil {
+			return nil, err
+		}
+		if results[i], err = parseScriptReply(vals); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// scriptArgs returns the KEYS and ARGV of the strategy script running op
+func (r *RedisClient) scriptArgs(op *AtomicOp) ([]string, []interface{}) {
+	encoding := "json"
+	if r.codec == BinaryCodec {
+		encoding = "binary"
+	}
+
+	return []string{op.Key, op.BlockKey}, []interface{}{
+		op.Kind, op.Points, op.Limit, ceilMilliseconds(op.Window), ceilMilliseconds(op.TTL), op.Now.UnixMilli(),
+		ceilMilliseconds(op.BlockDuration), encoding, op.Slot.UnixMilli(),
+	}
+}
+
+// parseScriptReply converts the reply of a strategy script into an AtomicResult
+func parseScriptReply(vals []int64) (*AtomicResult, error) {
+	if len(vals) != 6 {
+		return nil, fmt.Errorf("unexpected script reply length: %d", len(vals))
+	}
+
+	return &AtomicResult{
+		Exists:            vals[0] == 1,
+		Allowed:           vals[1] == 1,
+		RemainingPoints:   vals[2],
+		ConsumedPoints:    vals[3],
+		MsBeforeNext:      vals[4],
+		IsFirstInDuration: vals[5] == 1,
+	}, nil
+}
+
+// ceilMilliseconds rounds d up to whole milliseconds, the precision of the
+// scripts, so that a short positive TTL never becomes PX 0
+func ceilMilliseconds(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
 func (r *RedisClient) Close() error {
 	return r.client.Close()
 }
 
 // NewRedisStorageFromClient creates a Redis storage instance from an existing Redis client
+// Accepts any redis.UniversalClient: *redis.Client (including failover clients),
+// *redis.ClusterClient and *redis.Ring
 func NewRedisStorageFromClient(client interface{}) (Storage, error) {
-	redisClient, ok := client.(*redis.Client)
+	return NewRedisStorageWithCodec(client, JSONCodec)
+}
+
+// NewRedisStorageWithCodec creates a Redis storage instance from an existing
+// Redis client, storing the strategy state with codec. The strategies run as
+// Lua scripts, which implement JSONCodec and BinaryCodec only
+func NewRedisStorageWithCodec(client interface{}, codec Codec) (Storage, error) {
+	redisClient, ok := client.(redis.UniversalClient)
 	if !ok {
-		return nil, fmt.Errorf("invalid client type: expected *redis.Client, got %T", client)
+		return nil, fmt.Errorf("invalid client type: expected redis.UniversalClient, got %T", client)
 	}
-	
+	if codec == nil {
+		codec = JSONCodec
+	}
+	if codec != JSONCodec && codec != BinaryCodec {
+		return nil, fmt.Errorf("unsupported codec for redis: %T", codec)
+	}
+
 	return &RedisClient{
 		client: redisClient,
+		codec:  codec,
 	}, nil
 }
@@ -0,0 +1,790 @@
+package db
+
+import "github.com/redis/go-redis/v9"
+
+// Lua implementations of the rate limiting strategies executed by RedisClient.Atomic.
+//
+// Every script receives the state key as KEYS[1], the block key as KEYS[2] and the arguments
+// kind, points, limit, window (ms), ttl (ms), now (unix ms), block duration (ms), state
+// encoding ('json' or 'binary') and slot (unix ms, used by cancel), and returns
+// {exists, allowed, remainingPoints, consumedPoints, msBeforeNext, isFirstInDuration}.
+// For penalty and reward, allowed reports whether at least one point is left afterwards.
+// For reserve, allowed reports whether the points were reserved and msBeforeNext is the
+// delay before they may be used. Allowed leaky bucket consumes report the delay until
+// the queued request is processed in msBeforeNext. Cancel, implemented by the leaky bucket,
+// fixed window and sliding window counter, undoes the consume identified by slot: the leaky
+// bucket removes the request queued for the millisecond up to slot, the window strategies
+// give the points back to the window that was current at slot only.
+// State is kept as a JSON document with millisecond timestamps; state left by
+// versions that stored RFC3339 timestamps is discarded on first access.
+// With the binary encoding the fields listed in the script's layout are stored in
+// order after the bytes 0xb7 0x02, see stateCodec. Both encodings are read either way.
+// Version 1 is the different layout of BinaryCodec in Go, which the scripts discard
+// like other state they cannot read.
+
+// stateCodec encodes and decodes the state of a script in its binary layout, a list
+// of number fields and of list fields given as {name} for lists of numbers or
+// {name, field...} for lists of records. Numbers are varints holding the zigzag
+// encoded integer shifted left by one, or 1 followed by the 8 bytes of a double.
+// The first number of every list item is stored as the delta to the previous one
+const stateCodec = `
+local function writeVarint(out, u)
+	while u >= 128 do
+		out[#out + 1] = string.char(u % 128 + 128)
+		u = math.floor(u / 128)
+	end
+	out[#out + 1] = string.char(u)
+end
+
+local function readVarint(raw, pos)
+	local value, scale = 0, 1
+	while true do
+		local b = string.byte(raw, pos)
+		pos = pos + 1
+		value = value + (b % 128) * scale
+		if b < 128 then
+			return value, pos
+		end
+		scale = scale * 128
+	end
+end
+
+local function packDouble(x)
+	local sign = 0
+	if x < 0 or 1 / x < 0 then
+		sign = 128
+		x = -x
+	end
+	local mantissa, exponent = math.frexp(x)
+	if x == 0 then
+		mantissa, exponent = 0, 0
+	elseif x == math.huge then
+		mantissa, exponent = 0, 2047
+	else
+		exponent = exponent + 1022
+		if exponent <= 0 then
+			mantissa, exponent = math.ldexp(mantissa, exponent), 0
+		else
+			mantissa = mantissa * 2 - 1
+		end
+		mantissa = mantissa * 2 ^ 52
+	end
+	local bytes = {}
+	for i = 1, 6 do
+		bytes[i] = mantissa % 256
+		mantissa = math.floor(mantissa / 256)
+	end
+	bytes[7] = (exponent % 16) * 16 + mantissa
+	bytes[8] = sign + math.floor(exponent / 16)
+	return string.char(unpack(bytes))
+end
+
+local function unpackDouble(raw, pos)
+	local b1, b2, b3, b4, b5, b6, b7, b8 = string.byte(raw, pos, pos + 7)
+	local sign = 1
+	if b8 >= 128 then
+		sign = -1
+	end
+	local exponent = (b8 % 128) * 16 + math.floor(b7 / 16)
+	local mantissa = ((((((b7 % 16) * 256 + b6) * 256 + b5) * 256 + b4) * 256 + b3) * 256 + b2) * 256 + b1
+	if exponent == 0 then
+		return sign * math.ldexp(mantissa, -1074)
+	elseif exponent == 2047 then
+		return sign * math.huge
+	end
+	return sign * math.ldexp(mantissa + 2 ^ 52, exponent - 1075)
+end
+
+local function writeNumber(out, n)
+	if n == math.floor(n) and math.abs(n) < 2 ^ 50 then
+		local zigzag = n * 2
+		if n < 0 then
+			zigzag = -n * 2 - 1
+		end
+		writeVarint(out, zigzag * 2)
+	else
+		writeVarint(out, 1)
+		out[#out + 1] = packDouble(n)
+	end
+end
+
+local function readNumber(raw, pos)
+	local u
+	u, pos = readVarint(raw, pos)
+	if u == 1 then
+		return unpackDouble(raw, pos), pos + 8
+	end
+	local zigzag = u / 2
+	if zigzag % 2 == 0 then
+		return zigzag / 2, pos
+	end
+	return -(zigzag + 1) / 2, pos
+end
+
+local function encodeState(data)
+	if encoding ~= 'binary' then
+		return cjson.encode(data)
+	end
+	local out = {string.char(0xb7, 2)}
+	for _, field in ipairs(layout) do
+		if type(field) == 'string' then
+			writeNumber(out, data[field])
+		else
+			local list = data[field[1]]
+			writeVarint(out, #list)
+			local prev = 0
+			for _, item in ipairs(list) do
+				local first = item
+				if #field > 1 then
+					first = item[field[2]]
+				end
+				writeNumber(out, first - prev)
+				prev = first
+				for i = 3, #field do
+					writeNumber(out, item[field[i]])
+				end
+			end
+		end
+	end
+	return table.concat(out)
+end
+
+local function decodeState(raw)
+	if string.byte(raw, 1) ~= 0xb7 then
+		return cjson.decode(raw)
+	end
+	if string.byte(raw, 2) ~= 2 then
+		return nil
+	end
+	local data, pos = {}, 3
+	for _, field in ipairs(layout) do
+		if type(field) == 'string' then
+			data[field], pos = readNumber(raw, pos)
+		else
+			local count
+			count, pos = readVarint(raw, pos)
+			local list, prev = {}, 0
+			for i = 1, count do
+				local delta
+				delta, pos = readNumber(raw, pos)
+				prev = prev + delta
+				if #field == 1 then
+					list[i] = prev
+				else
+					local item = {[field[2]] = prev}
+					for j = 3, #field do
+						item[field[j]], pos = readNumber(raw, pos)
+					end
+					list[i] = item
+				end
+			end
+			data[field[1]] = list
+		end
+	end
+	return data
+end
+`
+
+const scriptPreamble = `
+local kind = ARGV[1]
+local points = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local window = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+local now = tonumber(ARGV[6])
+local blockDuration = tonumber(ARGV[7])
+local encoding = ARGV[8]
+local slot = tonumber(ARGV[9])
+` + stateCodec + `
+
+if kind == 'consume' or kind == 'get' or kind == 'reserve' then
+	local blockedUntil = tonumber(redis.call('GET', KEYS[2]))
+	if blockedUntil and blockedUntil > now then
+		return {1, 0, 0, limit, blockedUntil - now, 0}
+	end
+end
+
+local data = nil
+local raw = redis.call('GET', KEYS[1])
+if raw then
+	data = decodeState(raw)
+end
+
+local function strategy()
+`
+
+// scriptEpilogue closes the strategy function opened by scriptPreamble and
+// blocks the key when a consume is denied and a block duration is configured
+const scriptEpilogue = `
+end
+
+local result = strategy()
+if kind == 'consume' and result[2] == 0 and blockDuration > 0 then
+	redis.call('SET', KEYS[2], string.format('%d', now + blockDuration), 'PX', blockDuration)
+	result[5] = blockDuration
+end
+return result
+`
+
+const tokenBucketScript = `
+local layout = {'tokens', 'last_refill', 'capacity', 'refill_rate'}
+` + scriptPreamble + `
+if data and type(data.last_refill) ~= 'number' then
+	data = nil
+end
+
+if kind == 'get' then
+	if not data then
+		return {0, 0, 0, 0, 0, 0}
+	end
+	local elapsed = math.max(now - data.last_refill, 0) / 1000
+	local tokens = math.floor(math.min(data.capacity, data.tokens + elapsed * data.refill_rate))
+	local allowed = 0
+	if tokens >= 1 then
+		allowed = 1
+	end
+	return {1, allowed, math.max(tokens, 0), data.capacity - tokens, 0, 0}
+end
+
+if kind == 'reward' and not data then
+	return {0, 1, limit, 0, 0, 0}
+end
+
+local exists = 1
+if not data then
+	exists = 0
+	data = {tokens = limit, last_refill = now, capacity = limit, refill_rate = limit / (window / 1000)}
+end
+
+local elapsed = math.max(now - data.last_refill, 0) / 1000
+data.tokens = math.min(data.capacity, data.tokens + elapsed * data.refill_rate)
+data.last_refill = now
+
+if kind == 'penalty' or kind == 'reward' then
+	if kind == 'penalty' then
+		-- Reserved tokens below zero are left alone
+		data.tokens = math.min(data.tokens, math.max(data.tokens - points, 0))
+	else
+		data.tokens = math.min(data.tokens + points, data.capacity)
+	end
+	redis.call('SET', KEYS[1], encodeState(data), 'PX', ttl)
+	local remaining = math.floor(data.tokens)
+	local allowed = 0
+	local msBeforeNext = 0
+	if remaining >= 1 then
+		allowed = 1
+	else
+		msBeforeNext = math.floor((1 - data.tokens) / data.refill_rate * 1000)
+	end
+	return {exists, allowed, math.max(remaining, 0), data.capacity - remaining, msBeforeNext, 0}
+end
+
+if kind == 'reserve' then
+	if data.tokens - points < -data.capacity then
+		local msBeforeNext = math.ceil(((points - data.capacity) - data.tokens) / data.refill_rate * 1000)
+		return {exists, 0, 0, data.capacity - math.floor(data.tokens), msBeforeNext, 0}
+	end
+	data.tokens = data.tokens - points
+	redis.call('SET', KEYS[1], encodeState(data), 'PX', ttl)
+	local remaining = math.floor(data.tokens)
+	local msBeforeNext = 0
+	if data.tokens < 0 then
+		msBeforeNext = math.ceil(-data.tokens / data.refill_rate * 1000)
+	end
+	return {exists, 1, math.max(remaining, 0), data.capacity - remaining, msBeforeNext, 0}
+end
+
+if data.tokens >= points then
+	data.tokens = data.tokens - points
+	redis.call('SET', KEYS[1], encodeState(data), 'PX', ttl)
+	local first = 0
+	if elapsed > window / 1000 then
+		first = 1
+	end
+	return {exists, 1, math.floor(data.tokens), points, 0, first}
+end
+
+local msBeforeNext = math.floor((points - data.tokens) / data.refill_rate * 1000)
+return {exists, 0, math.max(math.floor(data.tokens), 0), 0, msBeforeNext, 0}
+` + scriptEpilogue
+
+const leakyBucketScript = `
+local layout = {{'queue', 'timestamp', 'points'}, 'last_drain', 'drain_rate'}
+` + scriptPreamble + `
+if data and type(data.last_drain) ~= 'number' then
+	data = nil
+end
+
+local function queued(queue)
+	local total = 0
+	for _, req in ipairs(queue) do
+		total = total + req.points
+	end
+	return total
+end
+
+-- Drain whole points from the front of the queue, the time spent on a partly
+-- drained point carries over to the next call
+local function drain(data)
+	local drained = math.floor(math.max(now - data.last_drain, 0) / 1000 * data.drain_rate)
+	if drained >= queued(data.queue) then
+		data.queue = {}
+		data.last_drain = now
+		return
+	end
+	if drained <= 0 then
+		return
+	end
+	local rest = {}
+	local toDrain = drained
+	for _, req in ipairs(data.queue) do
+		if toDrain > 0 and toDrain >= req.points then
+			toDrain = toDrain - req.points
+		else
+			req.points = req.points - toDrain
+			toDrain = 0
+			rest[#rest + 1] = req
+		end
+	end
+	data.queue = rest
+	data.last_drain = data.last_drain + drained / data.drain_rate * 1000
+end
+
+-- Milliseconds until the given number of queued points has drained
+local function delay(pending)
+	return math.max(pending / data.drain_rate * 1000 - (now - data.last_drain), 0)
+end
+
+if kind == 'get' then
+	if not data then
+		return {0, 0, 0, 0, 0, 0}
+	end
+	drain(data)
+	local current = queued(data.queue)
+	local allowed = 0
+	if current < limit then
+		allowed = 1
+	end
+	return {1, allowed, math.max(limit - current, 0), current, 0, 0}
+end
+
+if (kind == 'reward' or kind == 'cancel') and not data then
+	return {0, 1, limit, 0, 0, 0}
+end
+
+local exists = 1
+if not data then
+	exists = 0
+	data = {queue = {}, last_drain = now, drain_rate = limit / (window / 1000)}
+end
+
+drain(data)
+
+local current = queued(data.queue)
+
+if kind == 'penalty' or kind == 'reward' or kind == 'cancel' then
+	if kind == 'penalty' then
+		local add = math.min(points, limit - current)
+		if add > 0 then
+			data.queue[#data.queue + 1] = {timestamp = now, points = add}
+		end
+	elseif kind == 'cancel' then
+		-- Remove the request queued for the slot, unless it has drained
+		for i = #data.queue, 1, -1 do
+			local ts = data.queue[i].timestamp
+			if ts > slot - 1 and ts <= slot then
+				table.remove(data.queue, i)
+				break
+			end
+		end
+	else
+		-- Refund the most recently queued points first
+		local toRemove = points
+		while toRemove > 0 and #data.queue > 0 do
+			local last = data.queue[#data.queue]
+			if last.points <= toRemove then
+				toRemove = toRemove - last.points
+				data.queue[#data.queue] = nil
+			else
+				last.points = last.points - toRemove
+				toRemove = 0
+			end
+		end
+	end
+	redis.call('SET', KEYS[1], encodeState(data), 'PX', ttl)
+	current = queued(data.queue)
+	local allowed = 0
+	local msBeforeNext = 0
+	if current < limit then
+		allowed = 1
+	else
+		msBeforeNext = math.ceil(delay(current + 1 - limit))
+	end
+	return {exists, allowed, math.max(limit - current, 0), current, msBeforeNext, 0}
+end
+
+if kind == 'reserve' then
+	if current + points > 2 * limit then
+		local msBeforeNext = math.ceil(delay(current + points - 2 * limit))
+		return {exists, 0, 0, current, msBeforeNext, 0}
+	end
+	data.queue[#data.queue + 1] = {timestamp = now, points = points}
+	redis.call('SET', KEYS[1], encodeState(data), 'PX', ttl)
+	current = current + points
+	local msBeforeNext = 0
+	if current > limit then
+		msBeforeNext = math.ceil(delay(current - limit))
+	end
+	return {exists, 1, math.max(limit - current, 0), current, msBeforeNext, 0}
+end
+
+if current + points <= limit then
+	-- Queue the request behind the points already waiting; it is processed
+	-- once they have drained
+	local wait = delay(current)
+	data.queue[#data.queue + 1] = {timestamp = now + wait, points = points}
+	redis.call('SET', KEYS[1], encodeState(data), 'PX', ttl)
+	local first = 0
+	if #data.queue == 1 then
+		first = 1
+	end
+	return {exists, 1, limit - (current + points), current + points, math.ceil(wait), first}
+end
+
+local msBeforeNext = math.ceil(delay(current + points - limit))
+return {exists, 0, math.max(limit - current, 0), current, msBeforeNext, 0}
+` + scriptEpilogue
+
+const slidingWindowScript = `
+local layout = {{'requests'}}
+` + scriptPreamble + `
+if data and type(data.requests) ~= 'table' then
+	data = nil
+end
+
+local windowStart = now - window
+local requests = {}
+if data then
+	for _, ts in ipairs(data.requests) do
+		if type(ts) ~= 'number' then
+			requests = {}
+			break
+		end
+		if ts > windowStart then
+			requests[#requests + 1] = ts
+		end
+	end
+end
+
+-- insert records count requests made at ts, keeping the log sorted when
+-- reserved requests are already recorded after ts
+local function insert(ts, count)
+	local i = #requests
+	while i > 0 and requests[i] > ts do
+		i = i - 1
+	end
+	for j = 1, count do
+		table.insert(requests, i + j, ts)
+	end
+end
+
+if kind == 'get' then
+	if not data or #data.requests == 0 then
+		return {0, 0, 0, 0, 0, 0}
+	end
+	local allowed = 0
+	if #requests < limit then
+		allowed = 1
+	end
+	return {1, allowed, math.max(limit - #requests, 0), #requests, 0, 0}
+end
+
+local exists = 0
+if data then
+	exists = 1
+end
+
+if kind == 'penalty' or kind == 'reward' then
+	if kind == 'reward' and not data then
+		return {0, 1, limit, 0, 0, 0}
+	end
+	if kind == 'penalty' then
+		local add = math.min(points, limit - #requests)
+		if add > 0 then
+			insert(now, add)
+		end
+	else
+		-- Refund the most recent requests first
+		for i = 1, math.min(points, #requests) do
+			requests[#requests] = nil
+		end
+	end
+	redis.call('SET', KEYS[1], encodeState({requests = requests}), 'PX', ttl)
+	local allowed = 0
+	local msBeforeNext = 0
+	if #requests < limit then
+		allowed = 1
+	elseif #requests > 0 then
+		msBeforeNext = math.max(requests[1] + window - now, 0)
+	end
+	return {exists, allowed, math.max(limit - #requests, 0), #requests, msBeforeNext, 0}
+end
+
+if kind == 'reserve' then
+	local count = #requests
+	if count + points > 2 * limit then
+		local msBeforeNext = requests[count + points - 2 * limit] + window - now
+		return {exists, 0, 0, count, msBeforeNext, 0}
+	end
+	-- The reservation fits once enough of the oldest requests have left the
+	-- window, which must happen within one window
+	local slot = now
+	if count + points > limit then
+		local oldest = requests[count + points - limit]
+		if oldest > now then
+			return {exists, 0, 0, count, oldest - now, 0}
+		end
+		slot = math.max(now, oldest + window)
+	end
+	insert(slot, points)
+	redis.call('SET', KEYS[1], encodeState({requests = requests}), 'PX', ttl)
+	return {exists, 1, math.max(limit - #requests, 0), #requests, slot - now, 0}
+end
+
+if #requests + points <= limit then
+	insert(now, points)
+	redis.call('SET', KEYS[1], encodeState({requests = requests}), 'PX', ttl)
+	local first = 0
+	if #requests == points then
+		first = 1
+	end
+	return {exists, 1, limit - #requests, #requests, 0, first}
+end
+
+if #requests > 0 then
+	local msBeforeNext = math.max(requests[1] + window - now, 0)
+	return {exists, 0, math.max(limit - #requests, 0), #requests, msBeforeNext, 0}
+end
+
+return {exists, 0, limit, 0, 0, 1}
+` + scriptEpilogue
+
+const fixedWindowScript = `
+local layout = {'count', 'window_start'}
+` + scriptPreamble + `
+local windowStart = now - (now % window)
+local msBeforeNext = windowStart + window - now
+
+-- Points reserved beyond the limit of a window carry over to the following
+-- windows, one limit per window
+local count = 0
+if data and type(data.window_start) == 'number' and data.window_start <= windowStart then
+	local elapsed = math.floor((windowStart - data.window_start) / window)
+	count = math.max(data.count - elapsed * limit, 0)
+end
+
+-- State is kept until the end of the next window, as far as reserved points carry over
+local stateTTL = msBeforeNext + window
+
+local remaining = math.max(limit - count, 0)
+
+if kind == 'get' then
+	if count == 0 then
+		return {0, 0, 0, 0, 0, 0}
+	end
+	local allowed = 0
+	if count <= limit then
+		allowed = 1
+	end
+	return {1, allowed, remaining, count, msBeforeNext, 0}
+end
+
+local exists = 0
+local first = 1
+if count > 0 then
+	exists = 1
+	first = 0
+end
+
+if kind == 'penalty' or kind == 'reward' or kind == 'cancel' then
+	if kind ~= 'penalty' and count == 0 then
+		return {0, 1, limit, 0, msBeforeNext, 0}
+	end
+	if kind == 'cancel' and slot - (slot % window) ~= windowStart then
+		-- Points counted in a window that is over have nothing to give back
+		local allowed = 0
+		if count < limit then
+			allowed = 1
+		end
+		return {1, allowed, remaining, count, msBeforeNext, 0}
+	end
+	if kind == 'penalty' then
+		count = math.max(count, math.min(count + points, limit))
+	else
+		count = math.max(count - points, 0)
+	end
+	redis.call('SET', KEYS[1], encodeState({count = count, window_start = windowStart}), 'PX', stateTTL)
+	local allowed = 0
+	if count < limit then
+		allowed = 1
+	end
+	return {exists, allowed, math.max(limit - count, 0), count, msBeforeNext, 0}
+end
+
+if kind == 'reserve' then
+	if count + points > 2 * limit then
+		return {exists, 0, remaining, count, msBeforeNext, 0}
+	end
+	count = count + points
+	redis.call('SET', KEYS[1], encodeState({count = count, window_start = windowStart}), 'PX', stateTTL)
+	local delay = 0
+	if count > limit then
+		delay = msBeforeNext
+	end
+	return {exists, 1, math.max(limit - count, 0), count, delay, first}
+end
+
+if count + points <= limit then
+	count = count + points
+	redis.call('SET', KEYS[1], encodeState({count = count, window_start = windowStart}), 'PX', stateTTL)
+	return {exists, 1, math.max(limit - count, 0), count, msBeforeNext, first}
+end
+
+return {exists, 0, remaining, count, msBeforeNext, first}
+` + scriptEpilogue
+
+const slidingWindowCounterScript = `
+local layout = {'count', 'prev_count', 'window_start'}
+` + scriptPreamble + `
+local windowStart = now - (now % window)
+local elapsed = now - windowStart
+
+-- Counts of the previous and of the current fixed window. Points reserved beyond the
+-- limit of a window carry over to the following windows, one limit per window
+local prev = 0
+local current = 0
+if data and type(data.window_start) == 'number' then
+	if data.window_start == windowStart then
+		prev = data.prev_count
+		current = data.count
+	elseif data.window_start < windowStart then
+		local elapsedWindows = math.floor((windowStart - data.window_start) / window)
+		prev = math.min(math.max(data.count - (elapsedWindows - 1) * limit, 0), limit)
+		current = math.min(math.max(data.count - elapsedWindows * limit, 0), limit)
+	end
+end
+
+-- State is kept until the end of the second next window: the next window weighs the
+-- current count, and points carried over to it are weighed by the window after
+local stateTTL = 3 * window - elapsed
+
+local exists = 0
+local first = 1
+if prev > 0 or current > 0 then
+	exists = 1
+	first = 0
+end
+
+-- Points consumed in the sliding window, rounded up. The previous window is weighted
+-- by the part of it the sliding window still covers, in points times milliseconds
+local function consumed()
+	return math.ceil((prev * (window - elapsed) + current * window) / window)
+end
+
+-- Milliseconds before the given points fit in the sliding window
+local function delay(p)
+	if prev * (window - elapsed) + (current + p) * window <= limit * window then
+		return 0
+	end
+	if p > limit then
+		return window - elapsed
+	end
+	if current + p <= limit then
+		return window - math.floor((limit - p - current) * window / prev) - elapsed
+	end
+	return window - elapsed + window - math.floor((limit - p) * window / current)
+end
+
+local function save()
+	redis.call('SET', KEYS[1], encodeState({count = current, prev_count = prev, window_start = windowStart}), 'PX', stateTTL)
+end
+
+local function status(found)
+	local used = consumed()
+	local allowed = 0
+	local msBeforeNext = 0
+	if used < limit then
+		allowed = 1
+	else
+		msBeforeNext = delay(1)
+	end
+	return {found, allowed, math.max(limit - used, 0), used, msBeforeNext, 0}
+end
+
+if kind == 'get' then
+	if exists == 0 then
+		return {0, 0, 0, 0, 0, 0}
+	end
+	return status(1)
+end
+
+if kind == 'penalty' or kind == 'reward' or kind == 'cancel' then
+	if kind ~= 'penalty' and exists == 0 then
+		return {0, 1, limit, 0, 0, 0}
+	end
+	local consumedWindow = slot - (slot % window)
+	if kind == 'penalty' then
+		local add = math.min(points, limit - consumed())
+		if add > 0 then
+			current = current + add
+		end
+	elseif kind == 'cancel' and consumedWindow ~= windowStart then
+		-- Points consumed in what is now the previous window are given back from
+		-- its count, points of older windows no longer count
+		if consumedWindow + window == windowStart then
+			prev = math.max(prev - points, 0)
+		end
+	else
+		-- Give back the points of the current window first
+		local fromCurrent = math.min(points, current)
+		current = current - fromCurrent
+		prev = math.max(prev - (points - fromCurrent), 0)
+	end
+	save()
+	return status(exists)
+end
+
+local wait = delay(points)
+
+if kind == 'reserve' then
+	-- Points beyond the limit are carried over to the next window
+	if current + points > limit then
+		wait = window - elapsed
+	end
+	if current + points > 2 * limit then
+		return {exists, 0, math.max(limit - consumed(), 0), consumed(), wait, 0}
+	end
+	current = current + points
+	save()
+	return {exists, 1, math.max(limit - consumed(), 0), consumed(), wait, first}
+end
+
+if wait > 0 then
+	return {exists, 0, math.max(limit - consumed(), 0), consumed(), wait, 0}
+end
+
+current = current + points
+save()
+return {exists, 1, math.max(limit - consumed(), 0), consumed(), 0, first}
+` + scriptEpilogue
+
+// strategyScripts maps strategy names to their Lua implementation.
+// redis.Script runs EVALSHA and falls back to EVAL when the script is not cached yet
+var strategyScripts = map[string]*redis.Script{
+	"token_bucket":   redis.NewScript(tokenBucketScript),
+	"leaky_bucket":   redis.NewScript(leakyBucketScript),
+	"sliding_window": redis.NewScript(slidingWindowScript),
+	"fixed_window":   redis.NewScript(fixedWindowScript),
+
+	"sliding_window_counter": redis.NewScript(slidingWindowCounterScript),
+}
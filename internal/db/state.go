@@ -0,0 +1,143 @@
+package db
+
+import "time"
+
+// Strategy state structures, stored by the backends under the state key of
+// an AtomicOp. Backends without native strategy support encode them as JSON,
+// MemoryStorage keeps them as Go values
+
+// TokenBucketData represents the state of a token bucket
+type TokenBucketData struct {
+	Tokens     float64   `json:"tokens"`
+	LastRefill time.Time `json:"last_refill"`
+	Capacity   int64     `json:"capacity"`
+	RefillRate float64   `json:"refill_rate"`
+}
+
+// LeakyBucketData represents the state of a leaky bucket
+type LeakyBucketData struct {
+	Queue     []QueuedRequest `json:"queue"`
+	LastDrain time.Time       `json:"last_drain"`
+	DrainRate float64         `json:"drain_rate"`
+}
+
+// QueuedRequest represents a request in the leaky bucket queue
+type QueuedRequest struct {
+	Timestamp time.Time `json:"timestamp"`
+	Points    int64     `json:"points"`
+}
+
+// SlidingWindowData represents the state of a sliding window
+type SlidingWindowData struct {
+	Requests []time.Time `json:"requests"`
+}
+
+// FixedWindowData represents the state of a fixed window
+type FixedWindowData struct {
+	Count       int64     `json:"count"`
+	WindowStart time.Time `json:"window_start"`
+}
+
+// SlidingWindowCounterData represents the state of a sliding window counter:
+// the counts of the current and of the previous fixed window
+type SlidingWindowCounterData struct {
+	Count       int64     `json:"count"`
+	PrevCount   int64     `json:"prev_count"`
+	WindowStart time.Time `json:"window_start"`
+}
+
+// nativeState is implemented by the strategy states, which MemoryStorage
+// copies in and out of AtomicOp.State instead of encoding them. States
+// without slices are copied without allocating
+type nativeState interface {
+	// load copies stored, a value returned by save, into the state and
+	// reports whether it holds a state of the same type
+	load(stored interface{}) bool
+
+	// save copies the state into stored, reusing it when it holds a state of
+	// the same type, and returns the value holding the copy
+	save(stored interface{}) interface{}
+}
+
+func (d *TokenBucketData) load(stored interface{}) bool {
+	return loadValue(d, stored)
+}
+
+func (d *TokenBucketData) save(stored interface{}) interface{} {
+	return saveValue(d, stored)
+}
+
+func (d *FixedWindowData) load(stored interface{}) bool {
+	return loadValue(d, stored)
+}
+
+func (d *FixedWindowData) save(stored interface{}) interface{} {
+	return saveValue(d, stored)
+}
+
+func (d *SlidingWindowCounterData) load(stored interface{}) bool {
+	return loadValue(d, stored)
+}
+
+func (d *SlidingWindowCounterData) save(stored interface{}) interface{} {
+	return saveValue(d, stored)
+}
+
+// The strategies modify the queue and request slices in place, so the copies
+// must not share their backing arrays
+
+func (d *LeakyBucketData) load(stored interface{}) bool {
+	if !loadValue(d, stored) {
+		return false
+	}
+	d.Queue = append([]QueuedRequest(nil), d.Queue...)
+	return true
+}
+
+func (d *LeakyBucketData) save(stored interface{}) interface{} {
+	dst, ok := stored.(*LeakyBucketData)
+	if !ok {
+		dst = new(LeakyBucketData)
+	}
+	queue := append(dst.Queue[:0], d.Queue...)
+	*dst = *d
+	dst.Queue = queue
+	return dst
+}
+
+func (d *SlidingWindowData) load(stored interface{}) bool {
+	if !loadValue(d, stored) {
+		return false
+	}
+	d.Requests = append([]time.Time(nil), d.Requests...)
+	return true
+}
+
+func (d *SlidingWindowData) save(stored interface{}) interface{} {
+	dst, ok := stored.(*SlidingWindowData)
+	if !ok {
+		dst = new(SlidingWindowData)
+	}
+	dst.Requests = append(dst.Requests[:0], d.Requests...)
+	return dst
+}
+
+// loadValue copies the state stored points to into dst
+func loadValue[T any](dst *T, stored interface{}) bool {
+	src, ok := stored.(*T)
+	if ok {
+		*dst = *src
+	}
+	return ok
+}
+
+// saveValue copies src into stored, allocating a new value unless stored
+// already points to a T
+func saveValue[T any](src *T, stored interface{}) interface{} {
+	dst, ok := stored.(*T)
+	if !ok {
+		dst = new(T)
+	}
+	*dst = *src
+	return dst
+}
@@ -0,0 +1,202 @@
+// Package fiberlimit provides Fiber middleware backed by a strigo.RateLimiter.
+//
+//	limiter, _ := strigo.New(&strigo.Options{Points: 100, Duration: 60})
+//
+//	app := fiber.New()
+//	app.Get("/api/data", fiberlimit.New(limiter, nil), dataHandler)
+//	app.Get("/api/report", fiberlimit.New(limiter, &fiberlimit.Options{Points: 5}), reportHandler)
+package fiberlimit
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/veyselaksin/strigo/v2"
+)
+
+// Options configures the middleware
+type Options struct {
+	// Next skips the middleware when it returns true
+	Next func(c *fiber.Ctx) bool
+
+	// KeyGenerator returns the rate limit key of the request
+	// Default: KeyByIP
+	KeyGenerator func(c *fiber.Ctx) string
+
+	// Points consumed by each request passing through this middleware
+	// Default: 1
+	Points int64
+
+	// CostFunc computes the points consumed by a request, overriding Points
+	CostFunc func(c *fiber.Ctx) int64
+
+	// LimitReached handles rejected requests
+	// Default: 429 Too Many Requests with a JSON body
+	LimitReached func(c *fiber.Ctx, result *strigo.Result) error
+
+	// SkipSuccessfulRequests only counts requests whose response status is >= 400
+	SkipSuccessfulRequests bool
+
+	// SkipFailedRequests only counts requests whose response status is < 400
+	SkipFailedRequests bool
+
+	// FailOpen lets requests through when the limiter returns an error.
+	// When false (fail closed), ErrorHandler is called
+	FailOpen bool
+
+	// ErrorHandler handles limiter errors when failing closed
+	// Default: 500 Internal Server Error with a JSON body
+	ErrorHandler func(c *fiber.Ctx, err error) error
+}
+
+// New returns middleware that consumes points from limiter for every request
+// and rejects requests once the key runs out of points. Rate limit headers
+// from Result.Headers are set on every limited response.
+//
+// With SkipSuccessfulRequests or SkipFailedRequests the points are still
+// consumed before the handler runs, so concurrent requests cannot overrun the
+// limit, and rewarded once the response status shows the request does not count
+func New(limiter *strigo.RateLimiter, opts *Options) fiber.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	keyGenerator := opts.KeyGenerator
+	if keyGenerator == nil {
+		keyGenerator = KeyByIP
+	}
+
+	limitReached := opts.LimitReached
+	if limitReached == nil {
+		limitReached = defaultLimitReached
+	}
+
+	errorHandler := opts.ErrorHandler
+	if errorHandler == nil {
+		errorHandler = defaultErrorHandler
+	}
+
+	cost := func(c *fiber.Ctx) int64 {
+		if opts.CostFunc != nil {
+			return opts.CostFunc(c)
+		}
+		if opts.Points > 0 {
+			return opts.Points
+		}
+		return 1
+	}
+
+	conditional := opts.SkipSuccessfulRequests || opts.SkipFailedRequests
+
+	return func(c *fiber.Ctx) error {
+		if opts.Next != nil && opts.Next(c) {
+			return c.Next()
+		}
+
+		key := keyGenerator(c)
+		points := cost(c)
+		ctx := c.UserContext()
+
+		result, err := limiter.ConsumeCtx(ctx, key, points)
+		if err != nil {
+			if opts.FailOpen {
+				return c.Next()
+			}
+			return errorHandler(c, err)
+		}
+
+		setHeaders(c, result)
+
+		if !result.Allowed {
+			return limitReached(c, result)
+		}
+
+		if !conditional {
+			return c.Next()
+		}
+
+		err = c.Next()
+		failed := err != nil || c.Response().StatusCode() >= fiber.StatusBadRequest
+		if (failed && opts.SkipFailedRequests) || (!failed && opts.SkipSuccessfulRequests) {
+			// The request keeps counting when the reward fails
+			if rewarded, rewardErr := limiter.RewardCtx(ctx, key, points); rewardErr == nil {
+				setHeaders(c, rewarded)
+			}
+		}
+		return err
+	}
+}
+
+func setHeaders(c *fiber.Ctx, result *strigo.Result) {
+	for name, value := range result.Headers() {
+		c.Set(name, value)
+	}
+}
+
+// defaultLimitReached mirrors the response of the web example
+func defaultLimitReached(c *fiber.Ctx, result *strigo.Result) error {
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":      "Rate limit exceeded",
+		"retryAfter": result.MsBeforeNext / 1000,
+		"remaining":  result.RemainingPoints,
+		"limit":      result.TotalHits,
+		"resetTime":  result.MsBeforeNext,
+	})
+}
+
+func defaultErrorHandler(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Rate limiter error",
+	})
+}
+
+// Strings returned by fiber.Ctx point into buffers reused by later requests.
+// The limiter keeps keys beyond the request, e.g. in its block cache, so key
+// generators return copies
+
+// KeyByIP uses the client IP address as reported by c.IP()
+func KeyByIP(c *fiber.Ctx) string {
+	return utils.CopyString(c.IP())
+}
+
+// KeyByHeader uses the value of the named request header, falling back to
+// the client IP when the header is missing
+func KeyByHeader(name string) func(c *fiber.Ctx) string {
+	return func(c *fiber.Ctx) string {
+		if value := c.Get(name); value != "" {
+			return utils.CopyString(value)
+		}
+		return KeyByIP(c)
+	}
+}
+
+// KeyByRouteAndIP limits each client separately on every route. Mounted with
+// app.Use or on a group, routes with parameters are limited per request path,
+// e.g. /users/1 and /users/2 separately
+func KeyByRouteAndIP(c *fiber.Ctx) string {
+	// Concatenating copies the strings
+	return c.Method() + ":" + routePath(c) + ":" + c.IP()
+}
+
+// routePath identifies the route of the request. Middleware mounted with
+// app.Use or on a group runs before the route is matched, and c.Route() is the
+// mount point then, which has no parameters. So only routes with parameters
+// are keyed by their pattern, all others by the request path, normalized like
+// Fiber matches it
+func routePath(c *fiber.Ctx) string {
+	route := c.Route()
+	if len(route.Params) > 0 {
+		return route.Path
+	}
+
+	path := c.Path()
+	config := c.App().Config()
+	if !config.CaseSensitive {
+		path = strings.ToLower(path)
+	}
+	if !config.StrictRouting && len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+	}
+	return path
+}
@@ -0,0 +1,274 @@
+// Package grpclimit provides gRPC server interceptors backed by a strigo.RateLimiter.
+//
+//	limiter, _ := strigo.New(&strigo.Options{Points: 100, Duration: 60})
+//
+//	server := grpc.NewServer(
+//		grpc.UnaryInterceptor(grpclimit.UnaryServerInterceptor(limiter, nil)),
+//		grpc.StreamInterceptor(grpclimit.StreamServerInterceptor(limiter, &grpclimit.Options{
+//			KeyFunc:    grpclimit.KeyByMetadata("x-api-key"),
+//			PerMessage: true,
+//		})),
+//	)
+//
+// Denied calls fail with codes.ResourceExhausted. The status carries an
+// errdetails.RetryInfo detail, and the rate limit headers from Result.Headers
+// are sent as lowercase trailers (retry-after, x-ratelimit-remaining, ...)
+package grpclimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/veyselaksin/strigo/v2"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// KeyFunc extracts the rate limit key of a call
+type KeyFunc func(ctx context.Context, fullMethod string) (string, error)
+
+// CostFunc returns the number of points a call (or stream message) consumes
+type CostFunc func(ctx context.Context, fullMethod string) int64
+
+// Options configures the interceptors
+type Options struct {
+	// KeyFunc extracts the rate limit key of the call
+	// Default: KeyByPeer
+	KeyFunc KeyFunc
+
+	// CostFunc returns the points consumed by the call
+	// Default: 1 point per call
+	CostFunc CostFunc
+
+	// Skip lets calls bypass the limiter entirely when it returns true
+	Skip func(ctx context.Context, fullMethod string) bool
+
+	// PerMessage makes the stream interceptor consume points for every message
+	// received from the client instead of once when the stream opens
+	PerMessage bool
+
+	// FailOpen lets calls through when the key cannot be extracted or the
+	// limiter returns an error. When false (fail closed), the call fails with
+	// the error returned by ErrorHandler
+	FailOpen bool
+
+	// ErrorHandler converts limiter errors when failing closed
+	// Default: codes.Internal
+	ErrorHandler func(ctx context.Context, fullMethod string, err error) error
+}
+
+type interceptor struct {
+	limiter *strigo.RateLimiter
+	opts    *Options
+}
+
+func newInterceptor(limiter *strigo.RateLimiter, opts *Options) *interceptor {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	merged := *opts
+	if merged.KeyFunc == nil {
+		merged.KeyFunc = KeyByPeer
+	}
+	if merged.ErrorHandler == nil {
+		merged.ErrorHandler = defaultErrorHandler
+	}
+
+	return &interceptor{limiter: limiter, opts: &merged}
+}
+
+// UnaryServerInterceptor returns an interceptor that consumes points for every unary call
+func UnaryServerInterceptor(limiter *strigo.RateLimiter, opts *Options) grpc.UnaryServerInterceptor {
+	i := newInterceptor(limiter, opts)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if i.opts.Skip != nil && i.opts.Skip(ctx, info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		result, err := i.consume(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			_ = grpc.SetTrailer(ctx, trailer(result))
+			if !result.Allowed {
+				return nil, exhausted(result)
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor that consumes points when a
+// stream opens, or for every received message when Options.PerMessage is set
+func StreamServerInterceptor(limiter *strigo.RateLimiter, opts *Options) grpc.StreamServerInterceptor {
+	i := newInterceptor(limiter, opts)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		if i.opts.Skip != nil && i.opts.Skip(ctx, info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		if i.opts.PerMessage {
+			stream := &limitedStream{ServerStream: ss, interceptor: i, fullMethod: info.FullMethod}
+			err := handler(srv, stream)
+			// Trailers accumulate, so only report the state after the last message
+			if stream.last != nil {
+				ss.SetTrailer(trailer(stream.last))
+			}
+			return err
+		}
+
+		result, err := i.consume(ctx, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		if result != nil {
+			ss.SetTrailer(trailer(result))
+			if !result.Allowed {
+				return exhausted(result)
+			}
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// consume returns a nil result without error when the call fails open
+func (i *interceptor) consume(ctx context.Context, fullMethod string) (*strigo.Result, error) {
+	key, err := i.opts.KeyFunc(ctx, fullMethod)
+	if err != nil {
+		if i.opts.FailOpen {
+			return nil, nil
+		}
+		return nil, i.opts.ErrorHandler(ctx, fullMethod, err)
+	}
+
+	points := int64(1)
+	if i.opts.CostFunc != nil {
+		points = i.opts.CostFunc(ctx, fullMethod)
+	}
+
+	result, err := i.limiter.ConsumeCtx(ctx, key, points)
+	if err != nil {
+		if i.opts.FailOpen {
+			return nil, nil
+		}
+		return nil, i.opts.ErrorHandler(ctx, fullMethod, err)
+	}
+
+	return result, nil
+}
+
+// limitedStream consumes points for every message received from the client
+type limitedStream struct {
+	grpc.ServerStream
+	interceptor *interceptor
+	fullMethod  string
+	last        *strigo.Result
+}
+
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	result, err := s.interceptor.consume(s.Context(), s.fullMethod)
+	if err != nil {
+		return err
+	}
+	if result != nil {
+		s.last = result
+		if !result.Allowed {
+			return exhausted(result)
+		}
+	}
+
+	return nil
+}
+
+// exhausted builds the ResourceExhausted status of a denied call
+func exhausted(result *strigo.Result) error {
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(time.Duration(result.MsBeforeNext) * time.Millisecond),
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// trailer converts the rate limit headers into gRPC metadata
+func trailer(result *strigo.Result) metadata.MD {
+	md := metadata.MD{}
+	for name, value := range result.Headers() {
+		md.Set(strings.ToLower(name), value)
+	}
+	return md
+}
+
+func defaultErrorHandler(ctx context.Context, fullMethod string, err error) error {
+	return status.Errorf(codes.Internal, "rate limiter error: %v", err)
+}
+
+// KeyByPeer uses the host of the client's peer address, or the full address
+// when it has no port (e.g. Unix sockets)
+func KeyByPeer(ctx context.Context, fullMethod string) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", errors.New("call has no peer address")
+	}
+
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host, nil
+	}
+	return addr, nil
+}
+
+// KeyByMetadata uses the first value of the named incoming metadata key
+func KeyByMetadata(name string) KeyFunc {
+	return func(ctx context.Context, fullMethod string) (string, error) {
+		values := metadata.ValueFromIncomingContext(ctx, name)
+		if len(values) == 0 || values[0] == "" {
+			return "", fmt.Errorf("missing %s metadata", name)
+		}
+		return values[0], nil
+	}
+}
+
+// KeyByMethod uses the full method name, limiting each method as a whole
+func KeyByMethod(ctx context.Context, fullMethod string) (string, error) {
+	return fullMethod, nil
+}
+
+// Keys combines several key functions into one key joined with ":",
+// e.g. Keys(KeyByPeer, KeyByMethod) limits each client per method
+func Keys(funcs ...KeyFunc) KeyFunc {
+	return func(ctx context.Context, fullMethod string) (string, error) {
+		parts := make([]string, 0, len(funcs))
+		for _, fn := range funcs {
+			part, err := fn(ctx, fullMethod)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, ":"), nil
+	}
+}
@@ -0,0 +1,238 @@
+// Package httplimit provides net/http middleware backed by a strigo.RateLimiter.
+//
+//	limiter, _ := strigo.New(&strigo.Options{Points: 100, Duration: 60})
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/api/", httplimit.New(limiter, &httplimit.Options{
+//		KeyFunc:  httplimit.KeyByHeader("X-API-Key"),
+//		FailOpen: true,
+//	})(apiHandler))
+package httplimit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/veyselaksin/strigo/v2"
+)
+
+// KeyFunc extracts the rate limit key from a request
+type KeyFunc func(r *http.Request) (string, error)
+
+// CostFunc returns the number of points a request consumes
+type CostFunc func(r *http.Request) int64
+
+// Options configures the middleware
+type Options struct {
+	// KeyFunc extracts the rate limit key from the request
+	// Default: KeyByIP
+	KeyFunc KeyFunc
+
+	// CostFunc returns the points consumed by the request
+	// Default: 1 point per request
+	CostFunc CostFunc
+
+	// Skip lets requests bypass the limiter entirely when it returns true
+	Skip func(r *http.Request) bool
+
+	// AllowList holds keys that are never rate limited
+	AllowList []string
+
+	// LimitReached writes the response for rejected requests
+	// Default: 429 Too Many Requests with a JSON body
+	LimitReached func(w http.ResponseWriter, r *http.Request, result *strigo.Result)
+
+	// FailOpen lets requests through when the key cannot be extracted or the
+	// limiter returns an error. When false (fail closed), ErrorHandler is called
+	FailOpen bool
+
+	// ErrorHandler writes the response when failing closed
+	// Default: 500 Internal Server Error with a JSON body
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// New returns middleware that consumes points from limiter for every request
+// and rejects requests once the key runs out of points. Rate limit headers
+// from Result.Headers are set on every limited response
+func New(limiter *strigo.RateLimiter, opts *Options) func(http.Handler) http.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	keyFunc := opts.KeyFunc
+	if keyFunc == nil {
+		keyFunc = KeyByIP
+	}
+
+	limitReached := opts.LimitReached
+	if limitReached == nil {
+		limitReached = defaultLimitReached
+	}
+
+	errorHandler := opts.ErrorHandler
+	if errorHandler == nil {
+		errorHandler = defaultErrorHandler
+	}
+
+	allowList := make(map[string]struct{}, len(opts.AllowList))
+	for _, key := range opts.AllowList {
+		allowList[key] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if opts.Skip != nil && opts.Skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key, err := keyFunc(r)
+			if err != nil {
+				if opts.FailOpen {
+					next.ServeHTTP(w, r)
+					return
+				}
+				errorHandler(w, r, err)
+				return
+			}
+
+			if _, ok := allowList[key]; ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			points := int64(1)
+			if opts.CostFunc != nil {
+				points = opts.CostFunc(r)
+			}
+
+			result, err := limiter.ConsumeCtx(r.Context(), key, points)
+			if err != nil {
+				if opts.FailOpen {
+					next.ServeHTTP(w, r)
+					return
+				}
+				errorHandler(w, r, err)
+				return
+			}
+
+			for name, value := range result.Headers() {
+				w.Header().Set(name, value)
+			}
+
+			if !result.Allowed {
+				limitReached(w, r, result)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// defaultLimitReached writes a 429 response matching the examples in the repository
+func defaultLimitReached(w http.ResponseWriter, r *http.Request, result *strigo.Result) {
+	writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+		"error":      "Rate limit exceeded",
+		"retryAfter": result.MsBeforeNext / 1000,
+		"remaining":  result.RemainingPoints,
+		"limit":      result.TotalHits,
+	})
+}
+
+// defaultErrorHandler writes a 500 response for limiter failures
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+		"error": "Rate limiter error",
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// KeyByIP uses the client IP address taken from the request's RemoteAddr
+// Put the server behind a proxy that rewrites RemoteAddr, or use
+// KeyByHeader("X-Real-IP") with a trusted proxy
+func KeyByIP(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// RemoteAddr without a port
+		host = r.RemoteAddr
+	}
+	if host == "" {
+		return "", errors.New("request has no remote address")
+	}
+	return host, nil
+}
+
+// KeyByHeader uses the value of the named request header
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		value := r.Header.Get(name)
+		if value == "" {
+			return "", fmt.Errorf("missing %s header", name)
+		}
+		return value, nil
+	}
+}
+
+// KeyByPath uses the request URL path, limiting each endpoint as a whole
+func KeyByPath(r *http.Request) (string, error) {
+	return r.URL.Path, nil
+}
+
+// KeyByJWTClaim uses a claim of the bearer JWT in the Authorization header.
+// The token signature is NOT verified: register the middleware after the
+// authentication middleware that validates the token
+func KeyByJWTClaim(claim string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			return "", errors.New("missing or malformed bearer token")
+		}
+
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return "", fmt.Errorf("invalid token payload: %w", err)
+		}
+
+		var claims map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(payload))
+		decoder.UseNumber() // keep numeric IDs verbatim
+		if err := decoder.Decode(&claims); err != nil {
+			return "", fmt.Errorf("invalid token claims: %w", err)
+		}
+
+		value, ok := claims[claim]
+		if !ok || value == nil {
+			return "", fmt.Errorf("token has no %s claim", claim)
+		}
+		return fmt.Sprint(value), nil
+	}
+}
+
+// Keys combines several key functions into one key joined with ":",
+// e.g. Keys(KeyByIP, KeyByPath) limits each client per endpoint
+func Keys(funcs ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		parts := make([]string, 0, len(funcs))
+		for _, fn := range funcs {
+			part, err := fn(r)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, ":"), nil
+	}
+}
@@ -14,6 +14,10 @@ const (
 	LeakyBucket   Strategy = "leaky_bucket"   // Leaky bucket algorithm  
 	FixedWindow   Strategy = "fixed_window"   // Fixed time window counting
 	SlidingWindow Strategy = "sliding_window" // Sliding time window counting
+	
+	// SlidingWindowCounter approximates SlidingWindow with the weighted counts
+	// of the current and previous fixed windows, in constant storage per key
+	SlidingWindowCounter Strategy = "sliding_window_counter"
 )
 
 // Options represents the rate limiter configuration options
@@ -31,10 +35,20 @@ type Options struct {
 	// Default: TokenBucket
 	Strategy Strategy `json:"strategy,omitempty"`
 	
+	// Window defines the time window for point consumption with millisecond
+	// precision, e.g. 250*time.Millisecond. Takes precedence over Duration
+	Window time.Duration `json:"window,omitempty"`
+	
 	// BlockDuration defines how long to block key after limit exceeded (in seconds)
-	// Default: same as Duration
+	// Once a consume is denied the key stays blocked for BlockDuration regardless
+	// of refill, like blockDuration in rate-limiter-flexible
+	// Default: 0 (no automatic blocking)
 	BlockDuration int64 `json:"blockDuration,omitempty"`
 	
+	// BlockWindow is BlockDuration with millisecond precision
+	// Takes precedence over BlockDuration
+	BlockWindow time.Duration `json:"blockWindow,omitempty"`
+	
 	// KeyPrefix is used to create unique keys in the storage backend
 	// Default: "rl" (rate limiter)
 	KeyPrefix string `json:"keyPrefix,omitempty"`
@@ -46,6 +60,71 @@ type Options struct {
 	// StoreType specifies the type of store client ("redis", "memcached", "memory")
 	// Auto-detected if StoreClient is provided
 	StoreType string `json:"storeType,omitempty"`
+	
+	// Store is a custom storage backend implementing Storage
+	// Takes precedence over StoreClient and StoreType; closed by RateLimiter.Close
+	Store Storage `json:"-"`
+	
+	// Codec encodes the strategy state stored in Redis or Memcached, e.g.
+	// BinaryCodec to save memory. State written with the other built-in codec
+	// stays readable.
+	//
+	// Redis cannot use custom codecs: its Lua scripts implement JSONCodec and
+	// BinaryCodec themselves, and New fails for any other codec. Memcached
+	// accepts any Codec. Blocks and other values stored with SetJSON are
+	// always JSON. The stored state is not portable between backends
+	// Default: JSONCodec
+	Codec Codec `json:"-"`
+	
+	// MemoryMaxKeys bounds the number of keys held by the built-in memory
+	// store, evicting the least recently used ones, e.g. when keyed by client IP
+	// Default: 0 (unlimited)
+	MemoryMaxKeys int `json:"memoryMaxKeys,omitempty"`
+	
+	// MemoryCleanupInterval is how often the built-in memory store removes
+	// expired keys
+	// Default: 0 (every minute)
+	MemoryCleanupInterval time.Duration `json:"memoryCleanupInterval,omitempty"`
+	
+	// HeaderStyle selects the headers returned by Result.Headers
+	// Default: HeaderStyleLegacy
+	HeaderStyle HeaderStyle `json:"headerStyle,omitempty"`
+	
+	// Clock tells the time to the strategies and the built-in memory store.
+	// Set it to a clocktest.Clock to control time in tests
+	// Default: SystemClock
+	Clock Clock `json:"-"`
+	
+	// InsuranceLimiter serves calls while the storage backend returns errors,
+	// typically a memory limiter with Points divided by the number of instances.
+	// It is not closed by RateLimiter.Close
+	InsuranceLimiter *RateLimiter `json:"-"`
+	
+	// InsuranceRetryInterval is how long calls go straight to InsuranceLimiter
+	// after a storage error before the storage backend is tried again
+	// Default: 0 (try the storage backend on every call)
+	InsuranceRetryInterval time.Duration `json:"insuranceRetryInterval,omitempty"`
+	
+	// OnFailover is called with the storage error when calls start being
+	// served by InsuranceLimiter
+	OnFailover func(err error) `json:"-"`
+	
+	// OnRecover is called when calls are served by the storage backend again
+	OnRecover func() `json:"-"`
+	
+	// BlockCacheSize enables an in-process cache of up to BlockCacheSize keys
+	// denied by Consume. Cached keys are denied without calling the storage
+	// backend until their MsBeforeNext has passed, which shields Redis or
+	// Memcached from clients hammering a spent limit. The cache is local to
+	// the RateLimiter, so Reset and Reward on other instances are not seen
+	// Default: 0 (disabled)
+	BlockCacheSize int `json:"blockCacheSize,omitempty"`
+	
+	// ExecuteEvenly makes Consume wait for the slot of the request before
+	// returning, so admitted requests proceed evenly spaced at Points per
+	// Duration instead of in bursts. Only supported by LeakyBucket
+	// Default: false (return right away with the delay in MsBeforeNext)
+	ExecuteEvenly bool `json:"executeEvenly,omitempty"`
 }
 
 // NewOptions creates default options similar to rate-limiter-flexible
@@ -54,9 +133,10 @@ func NewOptions() *Options {
 		Points:        5,
 		Duration:      1,
 		Strategy:      TokenBucket,
-		BlockDuration: 0, // Will be set to Duration if 0
+		BlockDuration: 0, // No automatic blocking
 		KeyPrefix:     "rl",
 		StoreType:     "memory",
+		HeaderStyle:   HeaderStyleLegacy,
 	}
 }
 
@@ -66,13 +146,20 @@ func (o *Options) Validate() error {
 		return fmt.Errorf("points must be positive, got %d", o.Points)
 	}
 	
-	if o.Duration <= 0 {
+	if o.Window < 0 || o.Window%time.Millisecond != 0 {
+		return fmt.Errorf("window must be a positive whole number of milliseconds, got %s", o.Window)
+	}
+	
+	if o.Window == 0 && o.Duration <= 0 {
 		return fmt.Errorf("duration must be positive, got %d", o.Duration)
 	}
 	
-	// Set block duration to duration if not specified
-	if o.BlockDuration <= 0 {
-		o.BlockDuration = o.Duration
+	if o.BlockDuration < 0 {
+		return fmt.Errorf("block duration cannot be negative, got %d", o.BlockDuration)
+	}
+	
+	if o.BlockWindow < 0 || o.BlockWindow%time.Millisecond != 0 {
+		return fmt.Errorf("block window must be a whole number of milliseconds, got %s", o.BlockWindow)
 	}
 	
 	// Set default key prefix
@@ -87,21 +174,66 @@ func (o *Options) Validate() error {
 	
 	// Validate strategy
 	switch o.Strategy {
-	case TokenBucket, LeakyBucket, FixedWindow, SlidingWindow:
+	case TokenBucket, LeakyBucket, FixedWindow, SlidingWindow, SlidingWindowCounter:
 		// Valid strategies
 	default:
 		return fmt.Errorf("invalid strategy: %s", o.Strategy)
 	}
 	
+	if o.ExecuteEvenly && o.Strategy != LeakyBucket {
+		return fmt.Errorf("execute evenly requires the %s strategy, got %s", LeakyBucket, o.Strategy)
+	}
+	
+	if o.InsuranceRetryInterval < 0 {
+		return fmt.Errorf("insurance retry interval cannot be negative, got %s", o.InsuranceRetryInterval)
+	}
+	
+	if o.BlockCacheSize < 0 {
+		return fmt.Errorf("block cache size cannot be negative, got %d", o.BlockCacheSize)
+	}
+	
+	if o.MemoryMaxKeys < 0 {
+		return fmt.Errorf("memory max keys cannot be negative, got %d", o.MemoryMaxKeys)
+	}
+	
+	if o.MemoryCleanupInterval < 0 {
+		return fmt.Errorf("memory cleanup interval cannot be negative, got %s", o.MemoryCleanupInterval)
+	}
+	
+	// Set default clock
+	if o.Clock == nil {
+		o.Clock = SystemClock
+	}
+	
+	// Set default header style
+	if o.HeaderStyle == "" {
+		o.HeaderStyle = HeaderStyleLegacy
+	}
+	
+	switch o.HeaderStyle {
+	case HeaderStyleLegacy, HeaderStyleIETF, HeaderStyleBoth:
+		// Valid header styles
+	default:
+		return fmt.Errorf("invalid header style: %s", o.HeaderStyle)
+	}
+	
 	return nil
 }
 
-// GetDuration returns the duration as time.Duration
+// GetDuration returns the window as time.Duration, from Window when set and
+// from Duration otherwise
 func (o *Options) GetDuration() time.Duration {
+	if o.Window > 0 {
+		return o.Window
+	}
 	return time.Duration(o.Duration) * time.Second
 }
 
-// GetBlockDuration returns the block duration as time.Duration  
+// GetBlockDuration returns the block duration as time.Duration, from
+// BlockWindow when set and from BlockDuration otherwise
 func (o *Options) GetBlockDuration() time.Duration {
+	if o.BlockWindow > 0 {
+		return o.BlockWindow
+	}
 	return time.Duration(o.BlockDuration) * time.Second
-} 
\ No newline at end of file
+}
@@ -0,0 +1,106 @@
+package strigo
+
+import (
+	"sync"
+
+	"github.com/veyselaksin/strigo/v2/internal/db"
+)
+
+// Consume runs without allocating for the token bucket and fixed window: the
+// operation, its state and its Apply func come from a pool, the storage keys
+// from keyCache and the Result from resultPool.
+
+// pooledConsume is a consume operation kept in RateLimiter.consumeOps. It is
+// reused once the result of its op has been read
+type pooledConsume interface {
+	// prepare readies the operation to consume points from key
+	prepare(key string, points int64) *db.AtomicOp
+}
+
+// keyCacheShards splits keyCache so concurrent consumes rarely share a lock
+const keyCacheShards = 16
+
+// keyCacheShardSize is the number of keys a shard holds before it is cleared
+const keyCacheShardSize = 1024
+
+// keyCache remembers the state and block keys built for recently used keys.
+// A full shard is cleared rather than evicting keys one by one
+type keyCache struct {
+	shards [keyCacheShards]keyCacheShard
+}
+
+type keyCacheShard struct {
+	mu   sync.RWMutex
+	keys map[string]keyCacheEntry
+}
+
+type keyCacheEntry struct {
+	suffix   string
+	stateKey string
+	blockKey string
+}
+
+// cachedKeys returns the state key of key under suffix and its block key,
+// building them when they are not cached
+func (rl *RateLimiter) cachedKeys(key, suffix string) (stateKey, blockKey string) {
+	shard := &rl.keys.shards[keyCacheIndex(key)]
+
+	shard.mu.RLock()
+	cached, ok := shard.keys[key]
+	shard.mu.RUnlock()
+	if ok && cached.suffix == suffix {
+		return cached.stateKey, cached.blockKey
+	}
+
+	cached = keyCacheEntry{
+		suffix:   suffix,
+		stateKey: rl.buildStateKey(key, suffix),
+		blockKey: rl.buildBlockKey(key),
+	}
+
+	shard.mu.Lock()
+	if shard.keys == nil || len(shard.keys) >= keyCacheShardSize {
+		shard.keys = make(map[string]keyCacheEntry)
+	}
+	shard.keys[key] = cached
+	shard.mu.Unlock()
+
+	return cached.stateKey, cached.blockKey
+}
+
+// keyCacheIndex returns the shard of key, hashing it with FNV-1a
+func keyCacheIndex(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return hash % keyCacheShards
+}
+
+// resultBlockSize is the number of Results allocated at a time
+const resultBlockSize = 64
+
+// resultPool hands out Results allocated a block at a time. Every Result is
+// handed out once, so callers may keep them; a kept Result keeps the memory
+// of its block alive
+type resultPool struct {
+	blocks sync.Pool
+}
+
+type resultBlock struct {
+	results []Result
+}
+
+// get returns a zero Result
+func (p *resultPool) get() *Result {
+	block, _ := p.blocks.Get().(*resultBlock)
+	if block == nil || len(block.results) == 0 {
+		block = &resultBlock{results: make([]Result, resultBlockSize)}
+	}
+
+	result := &block.results[0]
+	block.results = block.results[1:]
+	p.blocks.Put(block)
+	return result
+}
@@ -2,8 +2,11 @@ package strigo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/veyselaksin/strigo/v2/internal/db"
@@ -11,8 +14,14 @@ import (
 
 // RateLimiter provides rate limiting functionality similar to rate-limiter-flexible
 type RateLimiter struct {
-	storage db.Storage
-	opts    *Options
+	storage   db.Storage
+	opts      *Options
+	insurance insurance
+	blocked   *blockCache // nil unless Options.BlockCacheSize is set
+
+	consumeOps sync.Pool // pooledConsume of the strategy, if it has one
+	keys       keyCache
+	results    resultPool
 }
 
 // New creates a new rate limiter instance with the given options
@@ -32,229 +41,679 @@ func New(opts *Options) (*RateLimiter, error) {
 		return nil, fmt.Errorf("failed to initialize storage: %w", err)
 	}
 	
-	return &RateLimiter{
+	rl := &RateLimiter{
 		storage: storage,
 		opts:    opts,
-	}, nil
+	}
+	if opts.BlockCacheSize > 0 {
+		rl.blocked = newBlockCache(opts.BlockCacheSize)
+	}
+	switch opts.Strategy {
+	case TokenBucket:
+		rl.consumeOps.New = func() interface{} { return rl.newTokenBucketConsume() }
+	case FixedWindow:
+		rl.consumeOps.New = func() interface{} { return rl.newFixedWindowConsume() }
+	}
+	
+	return rl, nil
 }
 
 // Consume attempts to consume the specified points for the given key
 // If no points are specified, defaults to 1 point
 func (rl *RateLimiter) Consume(key string, points ...int64) (*Result, error) {
-	// Default to 1 point if not specified
+	return rl.ConsumeCtx(context.Background(), key, points...)
+}
+
+// ConsumeCtx is like Consume but passes ctx to the storage backend, so the
+// call honours its cancellation and deadline
+func (rl *RateLimiter) ConsumeCtx(ctx context.Context, key string, points ...int64) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	consumePoints, err := pointsToConsume(points)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Keys denied moments ago are denied again without a storage round trip
+	if consumePoints > 0 {
+		if result := rl.cachedDenial(key, consumePoints); result != nil {
+			return result, nil
+		}
+	}
+	
+	result, err := rl.insured(ctx, func() (*Result, error) {
+		return rl.consume(ctx, key, consumePoints)
+	}, func(insurance *RateLimiter) (*Result, error) {
+		return insurance.ConsumeCtx(ctx, key, consumePoints)
+	})
+	if err != nil {
+		return nil, err
+	}
+	
+	rl.cacheDenial(key, consumePoints, result)
+	
+	// Wait for the slot of the request in the leaky bucket queue
+	if rl.opts.ExecuteEvenly && consumePoints > 0 {
+		if err := rl.waitForSlots(ctx, []string{key}, []*Result{result}, consumePoints); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// waitForSlots waits until the queue slots of the allowed results have come,
+// then clears their MsBeforeNext. When ctx ends first, the slots of every
+// allowed key are given up, as the caller gets no results
+func (rl *RateLimiter) waitForSlots(ctx context.Context, keys []string, results []*Result, points int64) error {
+	var wait int64
+	for _, result := range results {
+		if result.Allowed && result.MsBeforeNext > wait {
+			wait = result.MsBeforeNext
+		}
+	}
+	if wait == 0 {
+		return nil
+	}
+
+	if err := sleepCtx(ctx, time.Duration(wait)*time.Millisecond); err != nil {
+		for i, result := range results {
+			if !result.Allowed {
+				continue
+			}
+			if cancelErr := rl.cancelConsume(context.WithoutCancel(ctx), keys[i], points, result.slot); cancelErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to refund points: %w", cancelErr))
+			}
+		}
+		return err
+	}
+
+	for _, result := range results {
+		if result.Allowed {
+			result.MsBeforeNext = 0
+		}
+	}
+	return nil
+}
+
+// cancelConsume gives the points of an allowed consume of key back, see
+// cancel. The other strategies, whose consumes have no slot, are rewarded
+func (rl *RateLimiter) cancelConsume(ctx context.Context, key string, points int64, slot time.Time) error {
+	strategy := rl.opts.Strategy
+	if slot.IsZero() || (strategy != LeakyBucket && strategy != FixedWindow && strategy != SlidingWindowCounter) {
+		_, err := rl.RewardCtx(ctx, key, points)
+		return err
+	}
+	if rl.blocked != nil {
+		rl.blocked.remove(key)
+	}
+
+	_, err := rl.insured(ctx, func() (*Result, error) {
+		return rl.cancel(ctx, key, points, slot)
+	}, func(insurance *RateLimiter) (*Result, error) {
+		return nil, insurance.cancelConsume(ctx, key, points, slot)
+	})
+	return err
+}
+
+// pointsToConsume returns the optional points argument of the consume
+// methods, defaulting to 1 point
+func pointsToConsume(points []int64) (int64, error) {
 	consumePoints := int64(1)
 	if len(points) > 0 {
 		consumePoints = points[0]
 	}
 
-	// Validate points
 	if consumePoints < 0 {
-		return nil, fmt.Errorf("points cannot be negative")
+		return 0, fmt.Errorf("points cannot be negative")
 	}
+	return consumePoints, nil
+}
 
-	ctx := context.Background()
+// consume runs the consume operation of the configured strategy
+func (rl *RateLimiter) consume(ctx context.Context, key string, consumePoints int64) (*Result, error) {
+	var op *db.AtomicOp
+	if pooled, ok := rl.consumeOps.Get().(pooledConsume); ok {
+		// The result is converted before the operation goes back to the pool
+		defer rl.consumeOps.Put(pooled)
+		op = pooled.prepare(key, consumePoints)
+	} else {
+		op = rl.consumeOp(key, consumePoints)
+	}
+	
+	res, err := rl.storage.Atomic(ctx, op)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume %s: %w", rl.strategyName(), err)
+	}
 	
-	// Dispatch to strategy-specific implementation
+	return rl.newConsumeResult(op, res), nil
+}
+
+// newConsumeResult converts the outcome of a consume operation into a Result,
+// recording the slot identifying allowed consumes for cancelConsume
+func (rl *RateLimiter) newConsumeResult(op *db.AtomicOp, res *db.AtomicResult) *Result {
+	result := rl.newResult(res)
+	if !result.Allowed {
+		return result
+	}
+
 	switch rl.opts.Strategy {
-	case TokenBucket:
-		return rl.consumeTokenBucket(ctx, key, consumePoints)
 	case LeakyBucket:
-		return rl.consumeLeakyBucket(ctx, key, consumePoints)
+		result.slot = op.Now.Add(time.Duration(result.MsBeforeNext) * time.Millisecond)
+	case FixedWindow, SlidingWindowCounter:
+		result.slot = op.Now
+	}
+	return result
+}
+
+// consumeOp builds the consume operation of the configured strategy
+func (rl *RateLimiter) consumeOp(key string, consumePoints int64) *db.AtomicOp {
+	switch rl.opts.Strategy {
+	case LeakyBucket:
+		return rl.leakyBucketConsumeOp(key, consumePoints)
 	case SlidingWindow:
-		return rl.consumeSlidingWindow(ctx, key, consumePoints)
+		return rl.slidingWindowConsumeOp(key, consumePoints)
 	case FixedWindow:
-		return rl.consumeFixedWindow(ctx, key, consumePoints)
+		return rl.fixedWindowConsumeOp(key, consumePoints)
+	case SlidingWindowCounter:
+		return rl.slidingWindowCounterConsumeOp(key, consumePoints)
 	default:
-		// Default to TokenBucket for unknown strategies
-		return rl.consumeTokenBucket(ctx, key, consumePoints)
+		return rl.tokenBucketConsumeOp(key, consumePoints)
 	}
 }
 
 // Get returns the current rate limit information for the given key without consuming points
 // Similar to rateLimiter.get(key) from rate-limiter-flexible
 func (rl *RateLimiter) Get(key string) (*Result, error) {
-	ctx := context.Background()
-	storageKey := rl.buildKey(key)
+	return rl.GetCtx(context.Background(), key)
+}
+
+// GetCtx is like Get but passes ctx to the storage backend
+func (rl *RateLimiter) GetCtx(ctx context.Context, key string) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
 	
-	// Strategy-specific get implementations
+	return rl.insured(ctx, func() (*Result, error) {
+		return rl.get(ctx, key)
+	}, func(insurance *RateLimiter) (*Result, error) {
+		return insurance.GetCtx(ctx, key)
+	})
+}
+
+// get runs the get operation of the configured strategy, returning nil when
+// the key has no state
+func (rl *RateLimiter) get(ctx context.Context, key string) (*Result, error) {
+	res, err := rl.storage.Atomic(ctx, rl.getOp(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s data: %w", rl.strategyName(), err)
+	}
+	if !res.Exists {
+		return nil, nil
+	}
+	
+	return rl.newResult(res), nil
+}
+
+// getOp builds the get operation of the configured strategy
+func (rl *RateLimiter) getOp(key string) *db.AtomicOp {
 	switch rl.opts.Strategy {
-	case TokenBucket:
-		return rl.getTokenBucket(ctx, storageKey)
 	case LeakyBucket:
-		return rl.getLeakyBucket(ctx, storageKey)
+		return rl.leakyBucketGetOp(key)
 	case SlidingWindow:
-		return rl.getSlidingWindow(ctx, storageKey)
+		return rl.slidingWindowGetOp(key)
 	case FixedWindow:
-		return rl.getFixedWindow(ctx, storageKey)
+		return rl.fixedWindowGetOp(key)
+	case SlidingWindowCounter:
+		return rl.slidingWindowCounterGetOp(key)
 	default:
-		return rl.getTokenBucket(ctx, storageKey)
+		return rl.tokenBucketGetOp(key)
 	}
 }
 
-// Strategy-specific Get implementations
+// strategyName returns the configured strategy in words, e.g. "token bucket"
+func (rl *RateLimiter) strategyName() string {
+	return strings.ReplaceAll(string(rl.opts.Strategy), "_", " ")
+}
 
-func (rl *RateLimiter) getTokenBucket(ctx context.Context, storageKey string) (*Result, error) {
-	dataKey := fmt.Sprintf("%s:tb", storageKey)
-	var data TokenBucketData
-	err := rl.storage.GetJSON(ctx, dataKey, &data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get token bucket data: %w", err)
+// ConsumeMany consumes the points from every key, as if Consume was called
+// for each key in turn, and returns their results in the order of keys.
+// Backends batch the operations: Redis runs them in one pipeline and
+// Memcached loads all states with one GetMulti. With ExecuteEvenly the call
+// waits once, until the latest slot of the requests has come
+func (rl *RateLimiter) ConsumeMany(keys []string, points ...int64) ([]*Result, error) {
+	return rl.ConsumeManyCtx(context.Background(), keys, points...)
+}
+
+// ConsumeManyCtx is like ConsumeMany but passes ctx to the storage backend
+func (rl *RateLimiter) ConsumeManyCtx(ctx context.Context, keys []string, points ...int64) ([]*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateKeys(keys); err != nil {
+		return nil, err
 	}
 	
-	if data.LastRefill.IsZero() {
-		return nil, nil // No data exists
+	consumePoints, err := pointsToConsume(points)
+	if err != nil {
+		return nil, err
 	}
 	
-	// Calculate current tokens
-	now := time.Now()
-	elapsed := now.Sub(data.LastRefill).Seconds()
-	tokensToAdd := elapsed * data.RefillRate
-	currentTokens := data.Tokens + tokensToAdd
-	if currentTokens > float64(data.Capacity) {
-		currentTokens = float64(data.Capacity)
+	// Only keys missing from the block cache reach the storage
+	results := make([]*Result, len(keys))
+	pending := make([]string, 0, len(keys))
+	indexes := make([]int, 0, len(keys))
+	for i, key := range keys {
+		if consumePoints > 0 {
+			if result := rl.cachedDenial(key, consumePoints); result != nil {
+				results[i] = result
+				continue
+			}
+		}
+		pending = append(pending, key)
+		indexes = append(indexes, i)
+	}
+	if len(pending) == 0 {
+		return results, nil
 	}
 	
-	return &Result{
-		MsBeforeNext:      0,
-		RemainingPoints:   int64(currentTokens),
-		ConsumedPoints:    data.Capacity - int64(currentTokens),
-		IsFirstInDuration: false,
-		TotalHits:         rl.opts.Points,
-		Allowed:           int64(currentTokens) >= 1,
-	}, nil
-}
-
-func (rl *RateLimiter) getLeakyBucket(ctx context.Context, storageKey string) (*Result, error) {
-	dataKey := fmt.Sprintf("%s:lb", storageKey)
-	var data LeakyBucketData
-	err := rl.storage.GetJSON(ctx, dataKey, &data)
+	var batch []*Result
+	_, err = rl.insured(ctx, func() (*Result, error) {
+		var err error
+		batch, err = rl.consumeMany(ctx, pending, consumePoints)
+		return nil, err
+	}, func(insurance *RateLimiter) (*Result, error) {
+		var err error
+		batch, err = insurance.ConsumeManyCtx(ctx, pending, consumePoints)
+		return nil, err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get leaky bucket data: %w", err)
+		return nil, err
 	}
 	
-	if data.LastDrain.IsZero() {
-		return nil, nil // No data exists
+	for j, result := range batch {
+		rl.cacheDenial(pending[j], consumePoints, result)
+		results[indexes[j]] = result
 	}
 	
-	// Calculate current queue size after drainage
-	now := time.Now()
-	elapsed := now.Sub(data.LastDrain).Seconds()
-	requestsToDrain := int64(elapsed * data.DrainRate)
-	currentQueue := rl.drainRequests(data.Queue, requestsToDrain)
-	
-	currentPoints := int64(0)
-	for _, req := range currentQueue {
-		currentPoints += req.Points
+	// Wait until the slots of all requests in their leaky bucket queues have come
+	if rl.opts.ExecuteEvenly && consumePoints > 0 {
+		if err := rl.waitForSlots(ctx, pending, batch, consumePoints); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// consumeMany runs the consume operations of keys as one storage batch
+func (rl *RateLimiter) consumeMany(ctx context.Context, keys []string, consumePoints int64) ([]*Result, error) {
+	ops := make([]*db.AtomicOp, len(keys))
+	for i, key := range keys {
+		ops[i] = rl.consumeOp(key, consumePoints)
 	}
 	
-	return &Result{
-		MsBeforeNext:      0,
-		RemainingPoints:   rl.opts.Points - currentPoints,
-		ConsumedPoints:    currentPoints,
-		IsFirstInDuration: false,
-		TotalHits:         rl.opts.Points,
-		Allowed:           currentPoints < rl.opts.Points,
-	}, nil
-}
-
-func (rl *RateLimiter) getSlidingWindow(ctx context.Context, storageKey string) (*Result, error) {
-	dataKey := fmt.Sprintf("%s:sw", storageKey)
-	var data SlidingWindowData
-	err := rl.storage.GetJSON(ctx, dataKey, &data)
+	res, err := db.AtomicBatch(ctx, rl.storage, ops)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get sliding window data: %w", err)
+		return nil, fmt.Errorf("failed to consume %s: %w", rl.strategyName(), err)
 	}
 	
-	if data.Requests == nil || len(data.Requests) == 0 {
-		return nil, nil // No data exists
+	results := make([]*Result, len(res))
+	for i := range res {
+		results[i] = rl.newConsumeResult(ops[i], res[i])
 	}
-	
-	// Remove old requests outside window
-	now := time.Now()
-	windowStart := now.Add(-rl.opts.GetDuration())
-	validRequests := rl.removeOldRequests(data.Requests, windowStart)
-	
-	return &Result{
-		MsBeforeNext:      0,
-		RemainingPoints:   rl.opts.Points - int64(len(validRequests)),
-		ConsumedPoints:    int64(len(validRequests)),
-		IsFirstInDuration: false,
-		TotalHits:         rl.opts.Points,
-		Allowed:           int64(len(validRequests)) < rl.opts.Points,
-	}, nil
+	return results, nil
 }
 
-func (rl *RateLimiter) getFixedWindow(ctx context.Context, storageKey string) (*Result, error) {
-	// Get current window information
-	windowStart := rl.getWindowStartFixed()
-	windowKey := fmt.Sprintf("%s:%d", storageKey, windowStart.Unix())
+// GetMany returns the current rate limit information of every key without
+// consuming points, in the order of keys. Keys without state get a nil
+// Result, like Get. The reads are batched like ConsumeMany
+func (rl *RateLimiter) GetMany(keys []string) ([]*Result, error) {
+	return rl.GetManyCtx(context.Background(), keys)
+}
+
+// GetManyCtx is like GetMany but passes ctx to the storage backend
+func (rl *RateLimiter) GetManyCtx(ctx context.Context, keys []string) ([]*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateKeys(keys); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return []*Result{}, nil
+	}
 	
-	// Get current count from storage
-	currentCount, err := rl.storage.Get(ctx, windowKey)
+	var results []*Result
+	_, err := rl.insured(ctx, func() (*Result, error) {
+		var err error
+		results, err = rl.getMany(ctx, keys)
+		return nil, err
+	}, func(insurance *RateLimiter) (*Result, error) {
+		var err error
+		results, err = insurance.GetManyCtx(ctx, keys)
+		return nil, err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current count: %w", err)
+		return nil, err
 	}
-	
-	// If no data exists, return nil (similar to rate-limiter-flexible)
-	if currentCount == 0 {
-		return nil, nil
+	return results, nil
+}
+
+// getMany runs the get operations of keys as one storage batch
+func (rl *RateLimiter) getMany(ctx context.Context, keys []string) ([]*Result, error) {
+	ops := make([]*db.AtomicOp, len(keys))
+	for i, key := range keys {
+		ops[i] = rl.getOp(key)
 	}
 	
-	// Calculate remaining points
-	remainingPoints := rl.opts.Points - currentCount
-	if remainingPoints < 0 {
-		remainingPoints = 0
+	res, err := db.AtomicBatch(ctx, rl.storage, ops)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s data: %w", rl.strategyName(), err)
 	}
 	
-	// Calculate time until next window
+	results := make([]*Result, len(res))
+	for i := range res {
+		if res[i].Exists {
+			results[i] = rl.newResult(res[i])
+		}
+	}
+	return results, nil
+}
+
+// Strategy-specific Get implementations
+
+func (rl *RateLimiter) tokenBucketGetOp(key string) *db.AtomicOp {
+	var data TokenBucketData
+	op := rl.newOp(db.OpGet, key, "tb", 0, &data)
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		if data.LastRefill.IsZero() {
+			return db.AtomicResult{}, false // No data exists
+		}
+
+		// Calculate current tokens
+		elapsed := max(op.Now.Sub(data.LastRefill), 0).Seconds()
+		tokensToAdd := elapsed * data.RefillRate
+		currentTokens := data.Tokens + tokensToAdd
+		if currentTokens > float64(data.Capacity) {
+			currentTokens = float64(data.Capacity)
+		}
+		tokens := int64(math.Floor(currentTokens))
+
+		return db.AtomicResult{
+			Exists:          true,
+			RemainingPoints: max(tokens, 0),
+			ConsumedPoints:  data.Capacity - tokens,
+			Allowed:         tokens >= 1,
+		}, false
+	}
+
+	return op
+}
+
+func (rl *RateLimiter) leakyBucketGetOp(key string) *db.AtomicOp {
+	var data LeakyBucketData
+	op := rl.newOp(db.OpGet, key, "lb", 0, &data)
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		if data.LastDrain.IsZero() {
+			return db.AtomicResult{}, false // No data exists
+		}
+
+		// Calculate current queue size after drainage
+		rl.drainLeakyBucket(&data, op.Now)
+		currentPoints := queuedPoints(data.Queue)
+
+		return db.AtomicResult{
+			Exists:          true,
+			RemainingPoints: max(rl.opts.Points-currentPoints, 0),
+			ConsumedPoints:  currentPoints,
+			Allowed:         currentPoints < rl.opts.Points,
+		}, false
+	}
+
+	return op
+}
+
+func (rl *RateLimiter) slidingWindowGetOp(key string) *db.AtomicOp {
+	var data SlidingWindowData
+	op := rl.newOp(db.OpGet, key, "sw", 0, &data)
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		if len(data.Requests) == 0 {
+			return db.AtomicResult{}, false // No data exists
+		}
+
+		// Remove old requests outside window
+		windowStart := op.Now.Add(-rl.opts.GetDuration())
+		validRequests := rl.removeOldRequests(data.Requests, windowStart)
+
+		return db.AtomicResult{
+			Exists:          true,
+			RemainingPoints: max(rl.opts.Points-int64(len(validRequests)), 0),
+			ConsumedPoints:  int64(len(validRequests)),
+			Allowed:         int64(len(validRequests)) < rl.opts.Points,
+		}, false
+	}
+
+	return op
+}
+
+func (rl *RateLimiter) fixedWindowGetOp(key string) *db.AtomicOp {
+	var data FixedWindowData
+	op := rl.newOp(db.OpGet, key, "fw", 0, &data)
+
+	// Get current window information
+	windowStart := rl.getWindowStartFixed(op.Now)
 	nextWindow := windowStart.Add(rl.opts.GetDuration())
-	msBeforeNext := time.Until(nextWindow).Milliseconds()
-	
-	result := &Result{
-		MsBeforeNext:      msBeforeNext,
-		RemainingPoints:   remainingPoints,
-		ConsumedPoints:    currentCount,
-		IsFirstInDuration: false,
-		TotalHits:         rl.opts.Points,
-		Allowed:           currentCount <= rl.opts.Points,
+
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		// If no data exists in the current window, report none (similar to rate-limiter-flexible)
+		count := rl.fixedWindowCount(&data, windowStart)
+		if count == 0 {
+			return db.AtomicResult{}, false
+		}
+
+		// Calculate remaining points
+		remainingPoints := rl.opts.Points - count
+		if remainingPoints < 0 {
+			remainingPoints = 0
+		}
+
+		return db.AtomicResult{
+			Exists:          true,
+			MsBeforeNext:    nextWindow.Sub(op.Now).Milliseconds(),
+			RemainingPoints: remainingPoints,
+			ConsumedPoints:  count,
+			Allowed:         count <= rl.opts.Points,
+		}, false
 	}
-	
-	return result, nil
+
+	return op
+}
+
+func (rl *RateLimiter) slidingWindowCounterGetOp(key string) *db.AtomicOp {
+	var data SlidingWindowCounterData
+	op := rl.newOp(db.OpGet, key, "swc", 0, &data)
+
+	windowStart := rl.getWindowStartFixed(op.Now)
+
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		prev, current := rl.slidingWindowCounts(&data, windowStart)
+		if prev == 0 && current == 0 {
+			return db.AtomicResult{}, false // No data exists
+		}
+
+		elapsed := op.Now.Sub(windowStart).Milliseconds()
+		consumed := rl.slidingWindowCounterConsumed(prev, current, elapsed)
+		result := db.AtomicResult{
+			Exists:          true,
+			RemainingPoints: max(rl.opts.Points-consumed, 0),
+			ConsumedPoints:  consumed,
+			Allowed:         consumed < rl.opts.Points,
+		}
+		if !result.Allowed {
+			result.MsBeforeNext = rl.slidingWindowCounterDelay(prev, current, 1, elapsed)
+		}
+		return result, false
+	}
+
+	return op
 }
 
 // Reset resets the rate limit for the given key
 // Similar to rateLimiter.delete(key) from rate-limiter-flexible
 func (rl *RateLimiter) Reset(key string) error {
-	ctx := context.Background()
+	return rl.ResetCtx(context.Background(), key)
+}
+
+// ResetCtx is like Reset but passes ctx to the storage backend
+func (rl *RateLimiter) ResetCtx(ctx context.Context, key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if rl.blocked != nil {
+		rl.blocked.remove(key)
+	}
+	
+	_, err := rl.insured(ctx, func() (*Result, error) {
+		return nil, rl.reset(ctx, key)
+	}, func(insurance *RateLimiter) (*Result, error) {
+		return nil, insurance.ResetCtx(ctx, key)
+	})
+	return err
+}
+
+// reset removes the state of every strategy and the block of key. Missing
+// keys are no error for Storage.Reset, so every error is returned, letting the
+// insurance limiter take over when e.g. the block could not be lifted
+func (rl *RateLimiter) reset(ctx context.Context, key string) error {
 	storageKey := rl.buildKey(key)
 	
-	// Reset all strategy-specific keys
-	strategies := []string{"tb", "lb", "sw"}
-	for _, strategy := range strategies {
-		dataKey := fmt.Sprintf("%s:%s", storageKey, strategy)
-		_ = rl.storage.Reset(ctx, dataKey) // Ignore errors for non-existent keys
+	// Reset all strategy-specific keys and lift any block
+	var errs []error
+	suffixes := []string{"tb", "lb", "sw", "fw", "swc", "block"}
+	for _, suffix := range suffixes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := rl.storage.Reset(ctx, rl.buildStateKey(key, suffix)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reset %s: %w", suffix, err))
+		}
 	}
 	
-	// Also reset the base key (for fixed window and backward compatibility)
-	return rl.storage.Reset(ctx, storageKey)
+	// Also reset the base key (for backward compatibility)
+	if err := rl.storage.Reset(ctx, storageKey); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
 }
 
 // Block blocks the key for the specified duration in seconds
 // Similar to rateLimiter.block(key, secDuration) from rate-limiter-flexible
+//
+// While blocked, Consume and Get report Allowed=false with MsBeforeNext set
+// to the remaining block time, whatever the strategy state says
 func (rl *RateLimiter) Block(key string, durationSec int64) error {
-	ctx := context.Background()
-	storageKey := rl.buildKey(key)
-	
-	// Set a high count that will block requests
-	blockKey := fmt.Sprintf("%s:block", storageKey)
+	return rl.BlockCtx(context.Background(), key, durationSec)
+}
+
+// BlockCtx is like Block but passes ctx to the storage backend
+func (rl *RateLimiter) BlockCtx(ctx context.Context, key string, durationSec int64) error {
+	if durationSec <= 0 {
+		return fmt.Errorf("block duration must be positive, got %d", durationSec)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
 	duration := time.Duration(durationSec) * time.Second
 	
-	// Set a very high count to block all requests
-	blockAmount := rl.opts.Points + 1000
-	_, err := rl.storage.Increment(ctx, blockKey, blockAmount, duration)
+	_, err := rl.insured(ctx, func() (*Result, error) {
+		// Store the unix millisecond timestamp at which the block ends
+		blockedUntil := rl.opts.Clock.Now().Add(duration).UnixMilli()
+		return nil, rl.storage.SetJSON(ctx, rl.buildBlockKey(key), blockedUntil, duration)
+	}, func(insurance *RateLimiter) (*Result, error) {
+		return nil, insurance.BlockCtx(ctx, key, durationSec)
+	})
+	if err == nil && rl.blocked != nil {
+		rl.blocked.add(key, rl.opts.Clock.Now().Add(duration), 0, 1)
+	}
 	return err
 }
 
+// Penalty consumes points from the key whether or not they are available,
+// e.g. to make a failed login cost more than a successful one. The consumed
+// points never exceed the limit and the key is never blocked by a penalty
+// Similar to rateLimiter.penalty(key, points) from rate-limiter-flexible
+//
+// The result reports Allowed=true while at least one point is left
+func (rl *RateLimiter) Penalty(key string, points int64) (*Result, error) {
+	return rl.PenaltyCtx(context.Background(), key, points)
+}
+
+// PenaltyCtx is like Penalty but passes ctx to the storage backend
+func (rl *RateLimiter) PenaltyCtx(ctx context.Context, key string, points int64) (*Result, error) {
+	return rl.adjust(ctx, db.OpPenalty, key, points)
+}
+
+// Reward gives consumed points back to the key, e.g. when the request turned
+// out to be served from a cache. Keys never get more than their limit back
+// Similar to rateLimiter.reward(key, points) from rate-limiter-flexible
+//
+// The result reports Allowed=true while at least one point is left. A
+// reward does not lift a block, use Reset for that
+func (rl *RateLimiter) Reward(key string, points int64) (*Result, error) {
+	return rl.RewardCtx(context.Background(), key, points)
+}
+
+// RewardCtx is like Reward but passes ctx to the storage backend
+func (rl *RateLimiter) RewardCtx(ctx context.Context, key string, points int64) (*Result, error) {
+	return rl.adjust(ctx, db.OpReward, key, points)
+}
+
+// adjust dispatches a penalty or reward to the strategy-specific implementation
+func (rl *RateLimiter) adjust(ctx context.Context, kind, key string, points int64) (*Result, error) {
+	if points <= 0 {
+		return nil, fmt.Errorf("%s points must be positive, got %d", kind, points)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	
+	// Rewarded keys may have points again
+	if kind == db.OpReward && rl.blocked != nil {
+		rl.blocked.remove(key)
+	}
+
+	return rl.insured(ctx, func() (*Result, error) {
+		switch rl.opts.Strategy {
+		case LeakyBucket:
+			return rl.adjustLeakyBucket(ctx, kind, key, points)
+		case SlidingWindow:
+			return rl.adjustSlidingWindow(ctx, kind, key, points)
+		case FixedWindow:
+			return rl.adjustFixedWindow(ctx, kind, key, points)
+		case SlidingWindowCounter:
+			return rl.adjustSlidingWindowCounter(ctx, kind, key, points)
+		default:
+			return rl.adjustTokenBucket(ctx, kind, key, points)
+		}
+	}, func(insurance *RateLimiter) (*Result, error) {
+		return insurance.adjust(ctx, kind, key, points)
+	})
+}
+
 // Close closes the rate limiter and cleans up resources
 func (rl *RateLimiter) Close() error {
 	if rl.storage != nil {
@@ -263,15 +722,67 @@ func (rl *RateLimiter) Close() error {
 	return nil
 }
 
+// CloseCtx is like Close but stops waiting for the storage backend to shut
+// down once ctx is done, returning ctx.Err()
+func (rl *RateLimiter) CloseCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rl.Close()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// validateKey rejects the empty key. Its hash tag would be empty, which Redis
+// Cluster ignores, so the state and block keys could land in different slots
+func validateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+	return nil
+}
+
+// validateKeys rejects keys holding the empty key
+func validateKeys(keys []string) error {
+	for _, key := range keys {
+		if err := validateKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // buildKey creates the full storage key with prefix
+// The key is wrapped in a Redis Cluster hash tag, so the state and block keys
+// derived from it hash to the same slot and can be used by one script
 func (rl *RateLimiter) buildKey(key string) string {
-	return fmt.Sprintf("%s:%s", rl.opts.KeyPrefix, key)
+	return rl.opts.KeyPrefix + ":{" + key + "}"
+}
+
+// buildStateKey creates the storage key holding the strategy state of key
+// under the strategy-specific suffix
+func (rl *RateLimiter) buildStateKey(key, suffix string) string {
+	return rl.opts.KeyPrefix + ":{" + key + "}:" + suffix
+}
+
+// buildBlockKey creates the storage key marking key as blocked
+func (rl *RateLimiter) buildBlockKey(key string) string {
+	return rl.opts.KeyPrefix + ":{" + key + "}:block"
 }
 
 // Deprecated: getWindowStart is replaced by strategy-specific implementations
 // This method is kept for backward compatibility but should not be used
 func (rl *RateLimiter) getWindowStart() time.Time {
-	now := time.Now()
+	now := rl.opts.Clock.Now()
 	duration := rl.opts.GetDuration()
 	
 	switch rl.opts.Strategy {
@@ -289,23 +800,44 @@ func (rl *RateLimiter) getWindowStart() time.Time {
 
 // initStorage initializes the appropriate storage backend
 func initStorage(opts *Options) (db.Storage, error) {
+	// A custom backend is used as is
+	if opts.Store != nil {
+		return opts.Store, nil
+	}
+	
 	// If no store client provided, use memory storage
 	if opts.StoreClient == nil {
-		return db.NewMemoryStorage(), nil
+		return newMemoryStorage(opts), nil
 	}
 	
 	// Auto-detect client type or use explicit store type
 	switch {
+	case isStorage(opts.StoreClient):
+		return opts.StoreClient.(db.Storage), nil
 	case opts.StoreType == "redis" || isRedisClient(opts.StoreClient):
-		return db.NewRedisStorageFromClient(opts.StoreClient)
+		return db.NewRedisStorageWithCodec(opts.StoreClient, opts.Codec)
 	case opts.StoreType == "memcached" || isMemcachedClient(opts.StoreClient):
-		return db.NewMemcachedStorageFromClient(opts.StoreClient)
+		return db.NewMemcachedStorageWithCodec(opts.StoreClient, opts.Codec)
 	default:
-		return db.NewMemoryStorage(), nil
+		return newMemoryStorage(opts), nil
 	}
 }
 
+// newMemoryStorage creates the built-in memory store configured by opts
+func newMemoryStorage(opts *Options) db.Storage {
+	return db.NewMemoryStorageWithOptions(db.MemoryOptions{
+		MaxKeys:         opts.MemoryMaxKeys,
+		CleanupInterval: opts.MemoryCleanupInterval,
+		Clock:           opts.Clock,
+	})
+}
+
 // Helper functions to detect client types
+func isStorage(client interface{}) bool {
+	_, ok := client.(db.Storage)
+	return ok
+}
+
 func isRedisClient(client interface{}) bool {
 	clientType := fmt.Sprintf("%T", client)
 	return strings.Contains(clientType, "redis")
@@ -0,0 +1,183 @@
+package strigo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/veyselaksin/strigo/v2/internal/db"
+)
+
+// Reservation holds points taken ahead of time by Reserve
+// Similar to rate.Reservation from golang.org/x/time/rate, but the points are
+// reserved in the storage backend and shared by every process using it
+type Reservation struct {
+	limiter *RateLimiter
+	key     string
+	points  int64
+	result  *Result
+
+	mu       sync.Mutex
+	canceled bool
+}
+
+// OK reports whether the points were reserved. Reservations fail while the
+// key is blocked or when the points would not be available within one
+// window; Delay then tells how long to wait before reserving again
+func (r *Reservation) OK() bool {
+	return r.result.Allowed
+}
+
+// Delay returns how long to wait before acting on the reservation
+func (r *Reservation) Delay() time.Duration {
+	return time.Duration(r.result.MsBeforeNext) * time.Millisecond
+}
+
+// Result returns the outcome of the reservation, e.g. to set rate limit headers
+func (r *Reservation) Result() *Result {
+	return r.result
+}
+
+// Cancel gives the reserved points back when the action will not be
+// performed after all. It does nothing for failed or canceled reservations
+func (r *Reservation) Cancel() error {
+	return r.CancelCtx(context.Background())
+}
+
+// CancelCtx is like Cancel but passes ctx to the storage backend
+func (r *Reservation) CancelCtx(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.result.Allowed || r.canceled {
+		return nil
+	}
+
+	if _, err := r.limiter.RewardCtx(ctx, r.key, r.points); err != nil {
+		return err
+	}
+	r.canceled = true
+	return nil
+}
+
+// Reserve takes points from the key whether or not they are available yet
+// and returns a reservation telling how long to wait before using them.
+// Points can be reserved at most one window ahead
+// If no points are specified, defaults to 1 point
+func (rl *RateLimiter) Reserve(key string, points ...int64) (*Reservation, error) {
+	return rl.ReserveCtx(context.Background(), key, points...)
+}
+
+// ReserveCtx is like Reserve but passes ctx to the storage backend
+func (rl *RateLimiter) ReserveCtx(ctx context.Context, key string, points ...int64) (*Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	reservePoints, err := pointsToConsume(points)
+	if err != nil {
+		return nil, err
+	}
+	if reservePoints == 0 || reservePoints > rl.opts.Points {
+		return nil, fmt.Errorf("reserved points must be between 1 and %d, got %d", rl.opts.Points, reservePoints)
+	}
+
+	var reservation *Reservation
+	_, err = rl.insured(ctx, func() (*Result, error) {
+		result, err := rl.reserve(ctx, key, reservePoints)
+		if err != nil {
+			return nil, err
+		}
+		reservation = &Reservation{limiter: rl, key: key, points: reservePoints, result: result}
+		return result, nil
+	}, func(insurance *RateLimiter) (*Result, error) {
+		var err error
+		reservation, err = insurance.ReserveCtx(ctx, key, reservePoints)
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+// reserve runs the reserve operation of the configured strategy
+func (rl *RateLimiter) reserve(ctx context.Context, key string, points int64) (*Result, error) {
+	var op *db.AtomicOp
+	switch rl.opts.Strategy {
+	case LeakyBucket:
+		op = rl.leakyBucketReserveOp(key, points)
+	case SlidingWindow:
+		op = rl.slidingWindowReserveOp(key, points)
+	case FixedWindow:
+		op = rl.fixedWindowReserveOp(key, points)
+	case SlidingWindowCounter:
+		op = rl.slidingWindowCounterReserveOp(key, points)
+	default:
+		op = rl.tokenBucketReserveOp(key, points)
+	}
+
+	res, err := rl.storage.Atomic(ctx, op)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve %s: %w", rl.strategyName(), err)
+	}
+
+	return rl.newResult(res), nil
+}
+
+// Wait blocks until the points may be used, reserving them and sleeping for
+// the reservation's delay. It returns an error without taking points when ctx
+// is done first, or right away when ctx's deadline comes before the delay
+// If no points are specified, defaults to 1 point
+func (rl *RateLimiter) Wait(ctx context.Context, key string, points ...int64) error {
+	for {
+		reservation, err := rl.ReserveCtx(ctx, key, points...)
+		if err != nil {
+			return err
+		}
+
+		delay := reservation.Delay()
+		if reservation.OK() && delay == 0 {
+			return nil
+		}
+		if !reservation.OK() && delay < time.Millisecond {
+			delay = time.Millisecond
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			if err := reservation.CancelCtx(context.WithoutCancel(ctx)); err != nil {
+				return err
+			}
+			return fmt.Errorf("waiting %s for key %q would exceed the context deadline", delay, key)
+		}
+
+		if err := sleepCtx(ctx, delay); err != nil {
+			if cancelErr := reservation.CancelCtx(context.WithoutCancel(ctx)); cancelErr != nil {
+				return cancelErr
+			}
+			return err
+		}
+		if reservation.OK() {
+			return nil
+		}
+		// The key was blocked or reserved too far ahead, try again
+	}
+}
+
+// sleepCtx waits for d, returning ctx.Err() when ctx is done first
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
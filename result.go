@@ -1,6 +1,7 @@
 package strigo
 
 import (
+	"fmt"
 	"strconv"
 	"time"
 )
@@ -25,16 +26,67 @@ type Result struct {
 	
 	// Whether the request was allowed
 	Allowed bool `json:"allowed"`
+	
+	// Header style and window of the limiter that produced the result
+	headerStyle HeaderStyle
+	window      time.Duration
+
+	// resetAt is when MsBeforeNext elapses, by the clock of the limiter
+	resetAt time.Time
+
+	// slot identifies an allowed consume: when the leaky bucket processes it,
+	// or when it was consumed for the fixed window and sliding window counter
+	slot time.Time
 }
 
-// Headers returns HTTP headers that can be set in HTTP responses
-// following common rate limiting header conventions
+// HeaderStyle selects the rate limit headers returned by Result.Headers
+type HeaderStyle string
+
+// Available header styles
+const (
+	// HeaderStyleLegacy emits X-RateLimit-Limit, X-RateLimit-Remaining,
+	// X-RateLimit-Reset (Unix timestamp) and Retry-After
+	HeaderStyleLegacy HeaderStyle = "legacy"
+
+	// HeaderStyleIETF emits the RateLimit and RateLimit-Policy structured fields
+	// of the IETF draft (draft-ietf-httpapi-ratelimit-headers) and Retry-After
+	HeaderStyleIETF HeaderStyle = "ietf"
+
+	// HeaderStyleBoth emits the legacy and the IETF headers
+	HeaderStyleBoth HeaderStyle = "both"
+)
+
+// policyName is the name of the quota policy in the IETF headers
+const policyName = "default"
+
+// Headers returns HTTP headers that can be set in HTTP responses, in the
+// style selected by Options.HeaderStyle (legacy X-RateLimit-* by default)
 func (r *Result) Headers() map[string]string {
+	return r.HeadersFor(r.headerStyle)
+}
+
+// HeadersFor returns the HTTP headers of the given style. An empty style
+// selects HeaderStyleLegacy
+func (r *Result) HeadersFor(style HeaderStyle) map[string]string {
 	headers := make(map[string]string)
 	
-	headers["X-RateLimit-Limit"] = toStr(r.TotalHits)
-	headers["X-RateLimit-Remaining"] = toStr(r.RemainingPoints)
-	headers["X-RateLimit-Reset"] = toStr(time.Now().Add(time.Duration(r.MsBeforeNext) * time.Millisecond).Unix())
+	if style != HeaderStyleIETF {
+		headers["X-RateLimit-Limit"] = toStr(r.TotalHits)
+		headers["X-RateLimit-Remaining"] = toStr(r.RemainingPoints)
+		headers["X-RateLimit-Reset"] = toStr(r.resetTime().Unix())
+	}
+	
+	if style == HeaderStyleIETF || style == HeaderStyleBoth {
+		// RateLimit-Policy: "default";q=100;w=60
+		policy := fmt.Sprintf("%q;q=%d", policyName, r.TotalHits)
+		if r.window > 0 {
+			policy += ";w=" + toStr(int64((r.window+time.Second-1)/time.Second))
+		}
+		headers["RateLimit-Policy"] = policy
+		
+		// RateLimit: "default";r=50;t=30 with t rounded up to whole seconds
+		headers["RateLimit"] = fmt.Sprintf("%q;r=%d;t=%d", policyName, r.RemainingPoints, (r.MsBeforeNext+999)/1000)
+	}
 	
 	if !r.Allowed {
 		headers["Retry-After"] = toStr(r.MsBeforeNext / 1000)
@@ -43,6 +95,15 @@ func (r *Result) Headers() map[string]string {
 	return headers
 }
 
+// resetTime returns when MsBeforeNext elapses. Results built outside of a
+// limiter have no clock, they count from the current time
+func (r *Result) resetTime() time.Time {
+	if !r.resetAt.IsZero() {
+		return r.resetAt
+	}
+	return time.Now().Add(time.Duration(r.MsBeforeNext) * time.Millisecond)
+}
+
 // Helper function to convert int64 to string
 func toStr(i int64) string {
 	return strconv.FormatInt(i, 10)
@@ -0,0 +1,105 @@
+package strigo
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/redis/go-redis/v9"
+	"github.com/veyselaksin/strigo/v2/internal/db"
+)
+
+// Storage is the interface implemented by rate limiter storage backends.
+// Set Options.Store to plug in a custom implementation; the storagetest
+// package provides a conformance suite for it
+//
+// Besides the counter and JSON primitives, a backend must implement Atomic:
+// load the state stored at op.Key into op.State, call op.Apply and persist
+// op.State with op.TTL when Apply asks for it, without letting concurrent
+// operations on the same key interleave. Blocks are stored at op.BlockKey as
+// a JSON unix millisecond timestamp, see AtomicOp.BlockedResult and
+// AtomicOp.BlockOnDenial
+type Storage = db.Storage
+
+// BatchStorage is optionally implemented by a Storage that can execute the
+// atomic operations of ConsumeMany and GetMany in one round trip. Storages
+// without it get one Atomic call per key
+type BatchStorage = db.BatchStorage
+
+// AtomicOp describes a strategy-aware operation passed to Storage.Atomic
+type AtomicOp = db.AtomicOp
+
+// AtomicResult is the outcome of Storage.Atomic
+type AtomicResult = db.AtomicResult
+
+// Operation kinds found in AtomicOp.Kind
+const (
+	OpConsume = db.OpConsume // Consume points from the strategy state
+	OpGet     = db.OpGet     // Read the strategy state without modifying it
+	OpPenalty = db.OpPenalty // Consume points whether or not they are available, up to the limit
+	OpReward  = db.OpReward  // Give consumed points back
+	OpReserve = db.OpReserve // Take points ahead of time, reporting when they may be used
+)
+
+// MemoryStorage is the built-in in-memory storage backend. Its janitor
+// goroutine removing expired keys runs until Close
+type MemoryStorage = db.MemoryStorage
+
+// MemoryStorageOptions configures NewMemoryStorageWithOptions
+type MemoryStorageOptions = db.MemoryOptions
+
+// Codec encodes the strategy state stored by the Redis and Memcached backends.
+// The built-in codecs read state written by each other, so a deployment can
+// switch codecs without resetting its limits. Redis only supports the
+// built-in codecs, which its Lua scripts implement in a layout of their own.
+// SetJSON and GetJSON always use JSON, and the memory store keeps state as Go
+// values and ignores the codec
+type Codec = db.Codec
+
+// Built-in codecs
+var (
+	// JSONCodec stores strategy state as JSON documents, the default
+	JSONCodec = db.JSONCodec
+
+	// BinaryCodec stores strategy state in a compact binary layout, a fraction
+	// of the size of JSON. Values written with SetJSON, like blocks, stay JSON
+	BinaryCodec = db.BinaryCodec
+)
+
+// NewMemoryStorage creates the built-in in-memory storage backend
+func NewMemoryStorage() Storage {
+	return db.NewMemoryStorage()
+}
+
+// NewMemoryStorageWithClock creates the built-in in-memory storage backend,
+// expiring keys according to clock
+func NewMemoryStorageWithClock(clock Clock) Storage {
+	return db.NewMemoryStorageWithClock(clock)
+}
+
+// NewMemoryStorageWithOptions creates the built-in in-memory storage backend
+// configured by opts, e.g. to bound the number of keys it holds
+func NewMemoryStorageWithOptions(opts MemoryStorageOptions) *MemoryStorage {
+	return db.NewMemoryStorageWithOptions(opts)
+}
+
+// NewRedisStorage creates a storage backend on top of an existing Redis client,
+// which may be a single node, failover, Ring or Cluster client
+func NewRedisStorage(client redis.UniversalClient) (Storage, error) {
+	return db.NewRedisStorageFromClient(client)
+}
+
+// NewRedisStorageWithCodec is like NewRedisStorage but stores the strategy
+// state with codec. The strategies run as Lua scripts on the Redis server,
+// which implement JSONCodec and BinaryCodec only
+func NewRedisStorageWithCodec(client redis.UniversalClient, codec Codec) (Storage, error) {
+	return db.NewRedisStorageWithCodec(client, codec)
+}
+
+// NewMemcachedStorage creates a storage backend on top of an existing Memcached client
+func NewMemcachedStorage(client *memcache.Client) (Storage, error) {
+	return db.NewMemcachedStorageFromClient(client)
+}
+
+// NewMemcachedStorageWithCodec is like NewMemcachedStorage but stores the
+// strategy state with codec
+func NewMemcachedStorageWithCodec(client *memcache.Client, codec Codec) (Storage, error) {
+	return db.NewMemcachedStorageWithCodec(client, codec)
+}
@@ -0,0 +1,421 @@
+// Package storagetest provides a conformance suite for strigo.Storage
+// implementations.
+//
+// A custom backend runs the suite from its own tests:
+//
+//	func TestMyStorage(t *testing.T) {
+//		storagetest.Run(t, func() strigo.Storage {
+//			return mystore.New(...)
+//		})
+//	}
+//
+// The factory is called once per subtest and must return a storage with no
+// keys left over from previous calls (or use a fresh namespace). The suite
+// closes every storage it creates.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+)
+
+var strategies = []strigo.Strategy{
+	strigo.TokenBucket,
+	strigo.LeakyBucket,
+	strigo.SlidingWindow,
+	strigo.FixedWindow,
+	strigo.SlidingWindowCounter,
+}
+
+// Run runs the conformance suite against the storages returned by newStorage.
+// Subtests that wait for keys to expire are skipped in -short mode
+func Run(t *testing.T, newStorage func() strigo.Storage) {
+	t.Run("Counters", func(t *testing.T) { testCounters(t, newStorage()) })
+	t.Run("JSON", func(t *testing.T) { testJSON(t, newStorage()) })
+	t.Run("Expiry", func(t *testing.T) { testExpiry(t, newStorage()) })
+
+	for _, strategy := range strategies {
+		strategy := strategy
+		t.Run(string(strategy), func(t *testing.T) {
+			t.Run("ConsumeUntilLimit", func(t *testing.T) { testConsumeUntilLimit(t, newStorage(), strategy) })
+			t.Run("Block", func(t *testing.T) { testBlock(t, newStorage(), strategy) })
+			t.Run("BlockDuration", func(t *testing.T) { testBlockDuration(t, newStorage(), strategy) })
+			t.Run("PenaltyReward", func(t *testing.T) { testPenaltyReward(t, newStorage(), strategy) })
+			t.Run("Batch", func(t *testing.T) { testBatch(t, newStorage(), strategy) })
+			t.Run("Reserve", func(t *testing.T) { testReserve(t, newStorage(), strategy) })
+			t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, newStorage(), strategy) })
+		})
+	}
+}
+
+func testCounters(t *testing.T, storage strigo.Storage) {
+	defer storage.Close()
+	ctx := context.Background()
+
+	count, err := storage.Get(ctx, "storagetest:counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count, "missing counter must read as zero")
+
+	count, err = storage.Increment(ctx, "storagetest:counter", 2, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	count, err = storage.Increment(ctx, "storagetest:counter", 3, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+
+	count, err = storage.Get(ctx, "storagetest:counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+
+	require.NoError(t, storage.Reset(ctx, "storagetest:counter"))
+
+	count, err = storage.Get(ctx, "storagetest:counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	require.NoError(t, storage.Reset(ctx, "storagetest:counter"), "resetting a missing key must not fail")
+}
+
+type document struct {
+	Name  string    `json:"name"`
+	Count int64     `json:"count"`
+	At    time.Time `json:"at"`
+}
+
+func testJSON(t *testing.T, storage strigo.Storage) {
+	defer storage.Close()
+	ctx := context.Background()
+
+	// A missing key leaves the destination untouched
+	missing := document{Name: "unchanged"}
+	require.NoError(t, storage.GetJSON(ctx, "storagetest:missing", &missing))
+	assert.Equal(t, "unchanged", missing.Name)
+
+	want := document{Name: "doc", Count: 42, At: time.Now().UTC().Truncate(time.Millisecond)}
+	require.NoError(t, storage.SetJSON(ctx, "storagetest:doc", want, time.Minute))
+
+	var got document
+	require.NoError(t, storage.GetJSON(ctx, "storagetest:doc", &got))
+	assert.Equal(t, want.Name, got.Name)
+	assert.Equal(t, want.Count, got.Count)
+	assert.True(t, want.At.Equal(got.At))
+
+	require.NoError(t, storage.Reset(ctx, "storagetest:doc"))
+
+	got = document{}
+	require.NoError(t, storage.GetJSON(ctx, "storagetest:doc", &got))
+	assert.Empty(t, got.Name)
+}
+
+func testExpiry(t *testing.T, storage strigo.Storage) {
+	defer storage.Close()
+	if testing.Short() {
+		t.Skip("skipping expiry test in short mode")
+	}
+	ctx := context.Background()
+
+	require.NoError(t, storage.SetJSON(ctx, "storagetest:expiring", document{Name: "doc"}, time.Second))
+	_, err := storage.Increment(ctx, "storagetest:expiring-counter", 1, time.Second)
+	require.NoError(t, err)
+
+	time.Sleep(2100 * time.Millisecond)
+
+	var got document
+	require.NoError(t, storage.GetJSON(ctx, "storagetest:expiring", &got))
+	assert.Empty(t, got.Name, "JSON value must expire")
+
+	count, err := storage.Get(ctx, "storagetest:expiring-counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count, "counter must expire")
+}
+
+func newLimiter(t *testing.T, storage strigo.Storage, opts *strigo.Options) *strigo.RateLimiter {
+	opts.Store = storage
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = fmt.Sprintf("storagetest:%d", time.Now().UnixNano())
+	}
+
+	limiter, err := strigo.New(opts)
+	require.NoError(t, err)
+	return limiter
+}
+
+func testConsumeUntilLimit(t *testing.T, storage strigo.Storage, strategy strigo.Strategy) {
+	limiter := newLimiter(t, storage, &strigo.Options{Points: 3, Duration: 60, Strategy: strategy})
+	defer limiter.Close()
+
+	status, err := limiter.Get("user")
+	require.NoError(t, err)
+	assert.Nil(t, status, "unknown key must have no state")
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Consume("user", 1)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed, "request %d should be allowed", i+1)
+		assert.Equal(t, int64(2-i), result.RemainingPoints)
+	}
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Greater(t, result.MsBeforeNext, int64(0))
+
+	status, err = limiter.Get("user")
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	assert.Equal(t, int64(0), status.RemainingPoints)
+
+	// State is kept per key
+	result, err = limiter.Consume("other", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	require.NoError(t, limiter.Reset("user"))
+
+	status, err = limiter.Get("user")
+	require.NoError(t, err)
+	assert.Nil(t, status, "reset key must have no state")
+}
+
+func testBlock(t *testing.T, storage strigo.Storage, strategy strigo.Strategy) {
+	limiter := newLimiter(t, storage, &strigo.Options{Points: 3, Duration: 60, Strategy: strategy})
+	defer limiter.Close()
+
+	require.NoError(t, limiter.Block("user", 30))
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Greater(t, result.MsBeforeNext, int64(28000))
+	assert.LessOrEqual(t, result.MsBeforeNext, int64(30000))
+
+	status, err := limiter.Get("user")
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	assert.False(t, status.Allowed)
+
+	require.NoError(t, limiter.Reset("user"))
+
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func testBlockDuration(t *testing.T, storage strigo.Storage, strategy strigo.Strategy) {
+	limiter := newLimiter(t, storage, &strigo.Options{Points: 1, Duration: 60, BlockDuration: 120, Strategy: strategy})
+	defer limiter.Close()
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(120000), result.MsBeforeNext)
+
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Greater(t, result.MsBeforeNext, int64(60000), "block must outlast the strategy window")
+}
+
+func testBatch(t *testing.T, storage strigo.Storage, strategy strigo.Strategy) {
+	limiter := newLimiter(t, storage, &strigo.Options{Points: 3, Duration: 60, BlockDuration: 120, Strategy: strategy})
+	defer limiter.Close()
+
+	// Repeated keys see the points consumed earlier in the batch
+	results, err := limiter.ConsumeMany([]string{"a", "b", "a", "a", "a", "a"}, 1)
+	require.NoError(t, err)
+	require.Len(t, results, 6)
+
+	allowed := []bool{true, true, true, true, false, false}
+	remaining := []int64{2, 2, 1, 0, 0, 0}
+	for i, result := range results {
+		assert.Equal(t, allowed[i], result.Allowed, "result %d", i)
+		assert.Equal(t, remaining[i], result.RemainingPoints, "result %d", i)
+	}
+	assert.Equal(t, int64(120000), results[4].MsBeforeNext, "the denial must block the key")
+	assert.Greater(t, results[5].MsBeforeNext, int64(60000), "later operations must see the block")
+
+	statuses, err := limiter.GetMany([]string{"b", "missing", "a"})
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	require.NotNil(t, statuses[0])
+	assert.Equal(t, int64(2), statuses[0].RemainingPoints)
+	assert.Nil(t, statuses[1], "unknown key must have no state")
+	require.NotNil(t, statuses[2])
+	assert.False(t, statuses[2].Allowed)
+}
+
+func testReserve(t *testing.T, storage strigo.Storage, strategy strigo.Strategy) {
+	limiter := newLimiter(t, storage, &strigo.Options{Points: 2, Duration: 60, Strategy: strategy})
+	defer limiter.Close()
+
+	reserve := func() *strigo.Reservation {
+		reservation, err := limiter.Reserve("user", 1)
+		require.NoError(t, err)
+		return reservation
+	}
+
+	// Available points are reserved without delay
+	for i := 0; i < 2; i++ {
+		reservation := reserve()
+		assert.True(t, reservation.OK())
+		assert.Zero(t, reservation.Delay())
+	}
+
+	// Up to one limit of points can be reserved ahead
+	var last *strigo.Reservation
+	for i := 0; i < 2; i++ {
+		last = reserve()
+		assert.True(t, last.OK())
+		assert.Greater(t, last.Delay(), time.Duration(0))
+		assert.LessOrEqual(t, last.Delay(), 60*time.Second)
+	}
+
+	denied := reserve()
+	assert.False(t, denied.OK())
+	assert.Greater(t, denied.Delay(), time.Duration(0))
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "reserved points must not be consumed again")
+	assert.Equal(t, int64(0), result.RemainingPoints)
+
+	status, err := limiter.Get("user")
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	assert.Equal(t, int64(0), status.RemainingPoints)
+
+	// Canceling gives the points back for another reservation
+	require.NoError(t, last.Cancel())
+	require.NoError(t, last.Cancel(), "canceling twice must not refund twice")
+	assert.True(t, reserve().OK())
+	assert.False(t, reserve().OK())
+
+	_, err = limiter.Reserve("user", 3)
+	assert.Error(t, err, "reservations larger than the limit can never be used")
+
+	require.NoError(t, limiter.Block("blocked", 30))
+	blocked := func() *strigo.Reservation {
+		reservation, err := limiter.Reserve("blocked", 1)
+		require.NoError(t, err)
+		return reservation
+	}()
+	assert.False(t, blocked.OK())
+	assert.Greater(t, blocked.Delay(), 28*time.Second)
+}
+
+func testPenaltyReward(t *testing.T, storage strigo.Storage, strategy strigo.Strategy) {
+	limiter := newLimiter(t, storage, &strigo.Options{Points: 5, Duration: 3600, Strategy: strategy})
+	defer limiter.Close()
+
+	// Nothing to give back to an unknown key
+	result, err := limiter.Reward("user", 2)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(5), result.RemainingPoints)
+
+	status, err := limiter.Get("user")
+	require.NoError(t, err)
+	assert.Nil(t, status, "a reward must not create state")
+
+	result, err = limiter.Penalty("user", 3)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(2), result.RemainingPoints)
+	assert.Equal(t, int64(3), result.ConsumedPoints)
+
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(1), result.RemainingPoints)
+
+	// A penalty takes more points than are left, up to the limit
+	result, err = limiter.Penalty("user", 10)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(0), result.RemainingPoints)
+	assert.Equal(t, int64(5), result.ConsumedPoints)
+	assert.Greater(t, result.MsBeforeNext, int64(0))
+
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	result, err = limiter.Reward("user", 2)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(2), result.RemainingPoints)
+
+	result, err = limiter.Consume("user", 2)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	// Rewards never exceed the limit
+	result, err = limiter.Reward("user", 100)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), result.RemainingPoints)
+
+	// Penalties and rewards apply to blocked keys without lifting the block
+	require.NoError(t, limiter.Block("user", 30))
+	_, err = limiter.Penalty("user", 1)
+	require.NoError(t, err)
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	require.NoError(t, limiter.Reset("user"))
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), result.RemainingPoints)
+
+	_, err = limiter.Penalty("user", 0)
+	assert.Error(t, err, "penalty points must be positive")
+	_, err = limiter.Reward("user", -1)
+	assert.Error(t, err, "reward points must be positive")
+}
+
+func testConcurrency(t *testing.T, storage strigo.Storage, strategy strigo.Strategy) {
+	limiter := newLimiter(t, storage, &strigo.Options{Points: 20, Duration: 60, Strategy: strategy})
+	defer limiter.Close()
+
+	const workers = 60
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := limiter.Consume("shared", 1)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if result.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 20, allowed, "concurrent consumers must never be over-admitted")
+}
@@ -5,57 +5,65 @@ import (
 	"fmt"
 	"math"
 	"time"
+
+	"github.com/veyselaksin/strigo/v2/internal/db"
 )
 
 // Strategy-specific data structures
 
 // TokenBucketData represents the state of a token bucket
-type TokenBucketData struct {
-	Tokens     float64   `json:"tokens"`
-	LastRefill time.Time `json:"last_refill"`
-	Capacity   int64     `json:"capacity"`
-	RefillRate float64   `json:"refill_rate"`
-}
+type TokenBucketData = db.TokenBucketData
 
 // LeakyBucketData represents the state of a leaky bucket
-type LeakyBucketData struct {
-	Queue     []QueuedRequest `json:"queue"`
-	LastDrain time.Time       `json:"last_drain"`
-	DrainRate float64         `json:"drain_rate"`
-}
+type LeakyBucketData = db.LeakyBucketData
 
 // QueuedRequest represents a request in the leaky bucket queue
-type QueuedRequest struct {
-	Timestamp time.Time `json:"timestamp"`
-	Points    int64     `json:"points"`
-}
+type QueuedRequest = db.QueuedRequest
 
 // SlidingWindowData represents the state of a sliding window
-type SlidingWindowData struct {
-	Requests []time.Time `json:"requests"`
+type SlidingWindowData = db.SlidingWindowData
+
+// FixedWindowData represents the state of a fixed window
+type FixedWindowData = db.FixedWindowData
+
+// SlidingWindowCounterData represents the state of a sliding window counter:
+// the counts of the current and of the previous fixed window
+type SlidingWindowCounterData = db.SlidingWindowCounterData
+
+// Strategy-specific implementations
+//
+// Each strategy is expressed as a db.AtomicOp: backends with native strategy
+// support (Redis) execute it server-side, the others run the Apply function
+// below as one read-modify-write of the stored state.
+
+// tokenBucketConsume is the consume operation of the token bucket, holding
+// the op, its state and its Apply func so it can be pooled
+type tokenBucketConsume struct {
+	rl   *RateLimiter
+	op   db.AtomicOp
+	data TokenBucketData
 }
 
-// FixedWindowData represents the state of a fixed window (legacy for compatibility)
-type FixedWindowData struct {
-	Count      int64     `json:"count"`
-	WindowStart time.Time `json:"window_start"`
+func (rl *RateLimiter) newTokenBucketConsume() *tokenBucketConsume {
+	c := &tokenBucketConsume{rl: rl}
+	c.op.Apply = c.apply
+	return c
 }
 
-// Strategy-specific implementations
+// tokenBucketConsumeOp implements the classic token bucket algorithm
+func (rl *RateLimiter) tokenBucketConsumeOp(key string, points int64) *db.AtomicOp {
+	return rl.newTokenBucketConsume().prepare(key, points)
+}
+
+func (c *tokenBucketConsume) prepare(key string, points int64) *db.AtomicOp {
+	c.data = TokenBucketData{}
+	c.rl.initOp(&c.op, db.OpConsume, key, "tb", points, &c.data)
+	return &c.op
+}
+
+func (c *tokenBucketConsume) apply(exists bool) (db.AtomicResult, bool) {
+	rl, data, now, points := c.rl, &c.data, c.op.Now, c.op.Points
 
-// consumeTokenBucket implements the classic token bucket algorithm
-func (rl *RateLimiter) consumeTokenBucket(ctx context.Context, key string, points int64) (*Result, error) {
-	now := time.Now()
-	storageKey := rl.buildKey(key)
-	dataKey := fmt.Sprintf("%s:tb", storageKey)
-	
-	// Get current bucket state
-	var data TokenBucketData
-	err := rl.storage.GetJSON(ctx, dataKey, &data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get token bucket data: %w", err)
-	}
-	
 	// Initialize if first time
 	if data.LastRefill.IsZero() {
 		data.Capacity = rl.opts.Points
@@ -63,267 +71,915 @@ func (rl *RateLimiter) consumeTokenBucket(ctx context.Context, key string, point
 		data.Tokens = float64(rl.opts.Points) // Start with full bucket
 		data.LastRefill = now
 	}
-	
+
 	// Calculate tokens to add based on elapsed time
-	elapsed := now.Sub(data.LastRefill).Seconds()
+	elapsed := max(now.Sub(data.LastRefill), 0).Seconds()
 	tokensToAdd := elapsed * data.RefillRate
 	data.Tokens = math.Min(float64(data.Capacity), data.Tokens+tokensToAdd)
 	data.LastRefill = now
-	
+
 	// Check if enough tokens available
 	if data.Tokens >= float64(points) {
 		data.Tokens -= float64(points)
-		
-		// Save updated state
-		err = rl.storage.SetJSON(ctx, dataKey, data, rl.opts.GetDuration()*2)
-		if err != nil {
-			return nil, fmt.Errorf("failed to save token bucket data: %w", err)
-		}
-		
-		return &Result{
-			MsBeforeNext:      0,
+
+		return db.AtomicResult{
+			Exists:            exists,
+			Allowed:           true,
 			RemainingPoints:   int64(data.Tokens),
 			ConsumedPoints:    points,
 			IsFirstInDuration: elapsed > rl.opts.GetDuration().Seconds(),
-			TotalHits:         rl.opts.Points,
-			Allowed:           true,
-		}, nil
+		}, true
 	}
-	
+
 	// Calculate time until enough tokens are available
 	tokensNeeded := float64(points) - data.Tokens
-	msBeforeNext := int64((tokensNeeded / data.RefillRate) * 1000)
-	
-	return &Result{
-		MsBeforeNext:      msBeforeNext,
-		RemainingPoints:   int64(data.Tokens),
-		ConsumedPoints:    0,
-		IsFirstInDuration: false,
-		TotalHits:         rl.opts.Points,
-		Allowed:           false,
-	}, nil
+
+	return db.AtomicResult{
+		Exists:          exists,
+		MsBeforeNext:    int64((tokensNeeded / data.RefillRate) * 1000),
+		RemainingPoints: max(int64(math.Floor(data.Tokens)), 0),
+	}, false
 }
 
-// consumeLeakyBucket implements the leaky bucket algorithm
-func (rl *RateLimiter) consumeLeakyBucket(ctx context.Context, key string, points int64) (*Result, error) {
-	now := time.Now()
-	storageKey := rl.buildKey(key)
-	dataKey := fmt.Sprintf("%s:lb", storageKey)
-	
-	// Get current bucket state
+// leakyBucketConsumeOp implements the leaky bucket algorithm
+func (rl *RateLimiter) leakyBucketConsumeOp(key string, points int64) *db.AtomicOp {
 	var data LeakyBucketData
-	err := rl.storage.GetJSON(ctx, dataKey, &data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get leaky bucket data: %w", err)
-	}
-	
-	// Initialize if first time
-	if data.LastDrain.IsZero() {
-		data.DrainRate = float64(rl.opts.Points) / rl.opts.GetDuration().Seconds()
-		data.LastDrain = now
-		data.Queue = make([]QueuedRequest, 0)
-	}
-	
-	// Drain bucket based on elapsed time
-	elapsed := now.Sub(data.LastDrain).Seconds()
-	requestsToDrain := int64(elapsed * data.DrainRate)
-	data.Queue = rl.drainRequests(data.Queue, requestsToDrain)
-	data.LastDrain = now
-	
-	// Calculate current queue size in points
-	currentPoints := int64(0)
-	for _, req := range data.Queue {
-		currentPoints += req.Points
-	}
-	
-	// Check if bucket has capacity
-	if currentPoints+points <= rl.opts.Points {
-		// Add to queue
-		data.Queue = append(data.Queue, QueuedRequest{
-			Timestamp: now,
-			Points:    points,
-		})
-		
-		// Save updated state
-		err = rl.storage.SetJSON(ctx, dataKey, data, rl.opts.GetDuration()*2)
-		if err != nil {
-			return nil, fmt.Errorf("failed to save leaky bucket data: %w", err)
-		}
-		
-		return &Result{
-			MsBeforeNext:      0,
-			RemainingPoints:   rl.opts.Points - (currentPoints + points),
-			ConsumedPoints:    currentPoints + points,
-			IsFirstInDuration: len(data.Queue) == 1,
-			TotalHits:         rl.opts.Points,
-			Allowed:           true,
-		}, nil
+	op := rl.newOp(db.OpConsume, key, "lb", points, &data)
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		now := op.Now
+
+		// Initialize if first time
+		if data.LastDrain.IsZero() {
+			data.DrainRate = float64(rl.opts.Points) / rl.opts.GetDuration().Seconds()
+			data.LastDrain = now
+			data.Queue = make([]QueuedRequest, 0)
+		}
+
+		// Drain bucket based on elapsed time
+		rl.drainLeakyBucket(&data, now)
+
+		// Calculate current queue size in points
+		currentPoints := queuedPoints(data.Queue)
+
+		// Check if bucket has capacity
+		if currentPoints+points <= rl.opts.Points {
+			// Queue the request behind the points already waiting; it is
+			// processed once they have drained
+			delay := rl.leakyBucketDelay(&data, currentPoints, now)
+			data.Queue = append(data.Queue, QueuedRequest{
+				Timestamp: now.Add(delay),
+				Points:    points,
+			})
+
+			return db.AtomicResult{
+				Exists:            exists,
+				Allowed:           true,
+				RemainingPoints:   rl.opts.Points - (currentPoints + points),
+				ConsumedPoints:    currentPoints + points,
+				MsBeforeNext:      ceilMilliseconds(delay),
+				IsFirstInDuration: len(data.Queue) == 1,
+			}, true
+		}
+
+		// Calculate delay based on drain rate
+		pointsOverflow := (currentPoints + points) - rl.opts.Points
+
+		return db.AtomicResult{
+			Exists:          exists,
+			MsBeforeNext:    ceilMilliseconds(rl.leakyBucketDelay(&data, pointsOverflow, now)),
+			RemainingPoints: max(rl.opts.Points-currentPoints, 0),
+			ConsumedPoints:  currentPoints,
+		}, false
 	}
-	
-	// Calculate delay based on drain rate
-	pointsOverflow := (currentPoints + points) - rl.opts.Points
-	msBeforeNext := int64((float64(pointsOverflow) / data.DrainRate) * 1000)
-	
-	return &Result{
-		MsBeforeNext:      msBeforeNext,
-		RemainingPoints:   rl.opts.Points - currentPoints,
-		ConsumedPoints:    currentPoints,
-		IsFirstInDuration: false,
-		TotalHits:         rl.opts.Points,
-		Allowed:           false,
-	}, nil
+
+	return op
 }
 
-// consumeSlidingWindow implements the sliding window algorithm
-func (rl *RateLimiter) consumeSlidingWindow(ctx context.Context, key string, points int64) (*Result, error) {
-	now := time.Now()
-	storageKey := rl.buildKey(key)
-	dataKey := fmt.Sprintf("%s:sw", storageKey)
-	windowStart := now.Add(-rl.opts.GetDuration())
-	
-	// Get current window state
+// slidingWindowConsumeOp implements the sliding window algorithm
+func (rl *RateLimiter) slidingWindowConsumeOp(key string, points int64) *db.AtomicOp {
 	var data SlidingWindowData
-	err := rl.storage.GetJSON(ctx, dataKey, &data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get sliding window data: %w", err)
-	}
-	
-	// Initialize if first time
-	if data.Requests == nil {
-		data.Requests = make([]time.Time, 0)
-	}
-	
-	// Remove old requests outside window
-	data.Requests = rl.removeOldRequests(data.Requests, windowStart)
-	
-	// Check if adding new requests would exceed limit
-	if int64(len(data.Requests))+points <= rl.opts.Points {
-		// Add new request timestamps
-		for i := int64(0); i < points; i++ {
-			data.Requests = append(data.Requests, now)
-		}
-		
-		// Save updated state
-		err = rl.storage.SetJSON(ctx, dataKey, data, rl.opts.GetDuration()*2)
-		if err != nil {
-			return nil, fmt.Errorf("failed to save sliding window data: %w", err)
-		}
-		
-		return &Result{
-			MsBeforeNext:      0,
-			RemainingPoints:   rl.opts.Points - int64(len(data.Requests)),
-			ConsumedPoints:    int64(len(data.Requests)),
-			IsFirstInDuration: len(data.Requests) == int(points),
-			TotalHits:         rl.opts.Points,
-			Allowed:           true,
-		}, nil
-	}
-	
-	// Calculate time until oldest request expires
-	if len(data.Requests) > 0 {
-		oldestRequest := data.Requests[0]
-		msBeforeNext := oldestRequest.Add(rl.opts.GetDuration()).Sub(now).Milliseconds()
-		if msBeforeNext < 0 {
-			msBeforeNext = 0
-		}
-		
-		return &Result{
-			MsBeforeNext:      msBeforeNext,
-			RemainingPoints:   rl.opts.Points - int64(len(data.Requests)),
-			ConsumedPoints:    int64(len(data.Requests)),
-			IsFirstInDuration: false,
-			TotalHits:         rl.opts.Points,
-			Allowed:           false,
-		}, nil
+	op := rl.newOp(db.OpConsume, key, "sw", points, &data)
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		now := op.Now
+		windowStart := now.Add(-rl.opts.GetDuration())
+
+		// Initialize if first time
+		if data.Requests == nil {
+			data.Requests = make([]time.Time, 0)
+		}
+
+		// Remove old requests outside window
+		data.Requests = rl.removeOldRequests(data.Requests, windowStart)
+
+		// Check if adding new requests would exceed limit
+		if int64(len(data.Requests))+points <= rl.opts.Points {
+			// Add new request timestamps
+			data.Requests = insertRequests(data.Requests, now, points)
+
+			return db.AtomicResult{
+				Exists:            exists,
+				Allowed:           true,
+				RemainingPoints:   rl.opts.Points - int64(len(data.Requests)),
+				ConsumedPoints:    int64(len(data.Requests)),
+				IsFirstInDuration: len(data.Requests) == int(points),
+			}, true
+		}
+
+		// Calculate time until oldest request expires
+		if len(data.Requests) > 0 {
+			oldestRequest := data.Requests[0]
+			msBeforeNext := oldestRequest.Add(rl.opts.GetDuration()).Sub(now).Milliseconds()
+			if msBeforeNext < 0 {
+				msBeforeNext = 0
+			}
+
+			return db.AtomicResult{
+				Exists:          exists,
+				MsBeforeNext:    msBeforeNext,
+				RemainingPoints: max(rl.opts.Points-int64(len(data.Requests)), 0),
+				ConsumedPoints:  int64(len(data.Requests)),
+			}, false
+		}
+
+		return db.AtomicResult{
+			Exists:            exists,
+			RemainingPoints:   rl.opts.Points,
+			IsFirstInDuration: true,
+		}, false
 	}
-	
-	return &Result{
-		MsBeforeNext:      0,
-		RemainingPoints:   rl.opts.Points,
-		ConsumedPoints:    0,
-		IsFirstInDuration: true,
-		TotalHits:         rl.opts.Points,
-		Allowed:           false,
-	}, nil
+
+	return op
 }
 
-// consumeFixedWindow implements the fixed window algorithm (existing implementation)
-func (rl *RateLimiter) consumeFixedWindow(ctx context.Context, key string, points int64) (*Result, error) {
-	storageKey := rl.buildKey(key)
-	
+// fixedWindowConsume is the consume operation of the fixed window, holding
+// the op, its state and its Apply func so it can be pooled
+type fixedWindowConsume struct {
+	rl          *RateLimiter
+	op          db.AtomicOp
+	data        FixedWindowData
+	windowStart time.Time
+	nextWindow  time.Time
+}
+
+func (rl *RateLimiter) newFixedWindowConsume() *fixedWindowConsume {
+	c := &fixedWindowConsume{rl: rl}
+	c.op.Apply = c.apply
+	return c
+}
+
+// fixedWindowConsumeOp implements the fixed window algorithm
+func (rl *RateLimiter) fixedWindowConsumeOp(key string, points int64) *db.AtomicOp {
+	return rl.newFixedWindowConsume().prepare(key, points)
+}
+
+func (c *fixedWindowConsume) prepare(key string, points int64) *db.AtomicOp {
+	c.data = FixedWindowData{}
+	c.rl.initOp(&c.op, db.OpConsume, key, "fw", points, &c.data)
+
 	// Get current window information
-	windowStart := rl.getWindowStartFixed()
-	windowKey := fmt.Sprintf("%s:%d", storageKey, windowStart.Unix())
-	
-	// Get current count from storage
-	currentCount, err := rl.storage.Get(ctx, windowKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current count: %w", err)
-	}
-	
+	c.windowStart = c.rl.getWindowStartFixed(c.op.Now)
+	c.nextWindow = c.windowStart.Add(c.rl.opts.GetDuration())
+	c.op.TTL = c.rl.fixedWindowTTL(c.op.Now, c.nextWindow)
+	return &c.op
+}
+
+func (c *fixedWindowConsume) apply(exists bool) (db.AtomicResult, bool) {
+	rl, data, now, points := c.rl, &c.data, c.op.Now, c.op.Points
+
+	// Counts from a previous window no longer apply, except reserved points
+	currentCount := rl.fixedWindowCount(data, c.windowStart)
+
 	// Check if this is the first request in the window
 	isFirstInDuration := currentCount == 0
-	
+
 	// Calculate if the request should be allowed
 	newCount := currentCount + points
 	allowed := newCount <= rl.opts.Points
-	
+
 	// Calculate remaining points
 	remainingPoints := rl.opts.Points - currentCount
 	if remainingPoints < 0 {
 		remainingPoints = 0
 	}
-	
+
 	// Calculate time until next window
-	nextWindow := windowStart.Add(rl.opts.GetDuration())
-	msBeforeNext := time.Until(nextWindow).Milliseconds()
-	
+	msBeforeNext := c.nextWindow.Sub(now).Milliseconds()
+
 	// If allowed, increment the counter
 	consumedPoints := currentCount
 	if allowed {
-		_, err = rl.storage.Increment(ctx, windowKey, points, rl.opts.GetDuration())
-		if err != nil {
-			return nil, fmt.Errorf("failed to increment counter: %w", err)
-		}
+		data.Count = newCount
+		data.WindowStart = c.windowStart
 		consumedPoints = newCount
 		remainingPoints = rl.opts.Points - newCount
 		if remainingPoints < 0 {
 			remainingPoints = 0
 		}
 	}
-	
-	result := &Result{
-		MsBeforeNext:      msBeforeNext,
+
+	return db.AtomicResult{
+		Exists:            currentCount > 0,
+		Allowed:           allowed,
 		RemainingPoints:   remainingPoints,
 		ConsumedPoints:    consumedPoints,
+		MsBeforeNext:      msBeforeNext,
 		IsFirstInDuration: isFirstInDuration,
+	}, allowed
+}
+
+// slidingWindowCounterConsumeOp approximates the sliding window with the
+// counts of two fixed windows, weighting the previous one by the part of it
+// the sliding window still covers. Unlike the sliding window log its state
+// has a constant size however many points are consumed
+func (rl *RateLimiter) slidingWindowCounterConsumeOp(key string, points int64) *db.AtomicOp {
+	var data SlidingWindowCounterData
+	op := rl.newOp(db.OpConsume, key, "swc", points, &data)
+
+	windowStart := rl.getWindowStartFixed(op.Now)
+	op.TTL = rl.slidingWindowCounterTTL(op.Now, windowStart)
+
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		prev, current := rl.slidingWindowCounts(&data, windowStart)
+		elapsed := op.Now.Sub(windowStart).Milliseconds()
+
+		if delay := rl.slidingWindowCounterDelay(prev, current, points, elapsed); delay > 0 {
+			consumed := rl.slidingWindowCounterConsumed(prev, current, elapsed)
+			return db.AtomicResult{
+				Exists:          prev > 0 || current > 0,
+				MsBeforeNext:    delay,
+				RemainingPoints: max(rl.opts.Points-consumed, 0),
+				ConsumedPoints:  consumed,
+			}, false
+		}
+
+		data.PrevCount, data.Count, data.WindowStart = prev, current+points, windowStart
+		consumed := rl.slidingWindowCounterConsumed(prev, data.Count, elapsed)
+
+		return db.AtomicResult{
+			Exists:            prev > 0 || current > 0,
+			Allowed:           true,
+			RemainingPoints:   max(rl.opts.Points-consumed, 0),
+			ConsumedPoints:    consumed,
+			IsFirstInDuration: prev == 0 && current == 0,
+		}, true
+	}
+
+	return op
+}
+
+// Penalty and Reward implementations
+//
+// Both run as kind db.OpPenalty or db.OpReward through the same atomic path
+// as consume. A penalty consumes points whether or not they are available but
+// never beyond the limit, a reward gives consumed points back. Neither is
+// affected by blocks, and the result reports whether a point is left.
+
+// adjustTokenBucket removes tokens from (penalty) or adds tokens to (reward) the bucket
+func (rl *RateLimiter) adjustTokenBucket(ctx context.Context, kind, key string, points int64) (*Result, error) {
+	var data TokenBucketData
+	op := rl.newOp(kind, key, "tb", points, &data)
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		now := op.Now
+
+		// A full bucket has nothing to give back
+		if data.LastRefill.IsZero() {
+			if kind == db.OpReward {
+				return db.AtomicResult{Allowed: true, RemainingPoints: rl.opts.Points}, false
+			}
+			data.Capacity = rl.opts.Points
+			data.RefillRate = float64(rl.opts.Points) / rl.opts.GetDuration().Seconds()
+			data.Tokens = float64(rl.opts.Points)
+			data.LastRefill = now
+		}
+
+		elapsed := max(now.Sub(data.LastRefill), 0).Seconds()
+		data.Tokens = math.Min(float64(data.Capacity), data.Tokens+elapsed*data.RefillRate)
+		data.LastRefill = now
+
+		if kind == db.OpPenalty {
+			// Reserved tokens below zero are left alone
+			data.Tokens = math.Min(data.Tokens, math.Max(data.Tokens-float64(points), 0))
+		} else {
+			data.Tokens = math.Min(data.Tokens+float64(points), float64(data.Capacity))
+		}
+
+		remaining := int64(math.Floor(data.Tokens))
+		result := db.AtomicResult{
+			Exists:          exists,
+			Allowed:         remaining >= 1,
+			RemainingPoints: max(remaining, 0),
+			ConsumedPoints:  data.Capacity - remaining,
+		}
+		if !result.Allowed {
+			result.MsBeforeNext = int64((1 - data.Tokens) / data.RefillRate * 1000)
+		}
+		return result, true
+	}
+
+	res, err := rl.storage.Atomic(ctx, op)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %s to token bucket: %w", kind, err)
+	}
+
+	return rl.newResult(res), nil
+}
+
+// adjustLeakyBucket queues extra points (penalty) or removes the most recently
+// queued points (reward)
+func (rl *RateLimiter) adjustLeakyBucket(ctx context.Context, kind, key string, points int64) (*Result, error) {
+	res, err := rl.storage.Atomic(ctx, rl.leakyBucketAdjustOp(kind, key, points))
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %s to leaky bucket: %w", kind, err)
+	}
+
+	return rl.newResult(res), nil
+}
+
+// leakyBucketAdjustOp builds the penalty, reward and cancel operations of the
+// leaky bucket
+func (rl *RateLimiter) leakyBucketAdjustOp(kind, key string, points int64) *db.AtomicOp {
+	var data LeakyBucketData
+	op := rl.newOp(kind, key, "lb", points, &data)
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		now := op.Now
+
+		// An empty bucket has nothing to give back
+		if data.LastDrain.IsZero() {
+			if kind != db.OpPenalty {
+				return db.AtomicResult{Allowed: true, RemainingPoints: rl.opts.Points}, false
+			}
+			data.DrainRate = float64(rl.opts.Points) / rl.opts.GetDuration().Seconds()
+			data.LastDrain = now
+			data.Queue = make([]QueuedRequest, 0)
+		}
+
+		rl.drainLeakyBucket(&data, now)
+
+		switch kind {
+		case db.OpPenalty:
+			if add := min(points, rl.opts.Points-queuedPoints(data.Queue)); add > 0 {
+				data.Queue = append(data.Queue, QueuedRequest{Timestamp: now, Points: add})
+			}
+		case db.OpCancel:
+			// Remove the request queued for the slot, unless it has drained
+			for i := len(data.Queue) - 1; i >= 0; i-- {
+				if ts := data.Queue[i].Timestamp; ts.After(op.Slot.Add(-time.Millisecond)) && !ts.After(op.Slot) {
+					data.Queue = append(data.Queue[:i], data.Queue[i+1:]...)
+					break
+				}
+			}
+		default:
+			for toRemove := points; toRemove > 0 && len(data.Queue) > 0; {
+				last := &data.Queue[len(data.Queue)-1]
+				if last.Points <= toRemove {
+					toRemove -= last.Points
+					data.Queue = data.Queue[:len(data.Queue)-1]
+				} else {
+					last.Points -= toRemove
+					toRemove = 0
+				}
+			}
+		}
+
+		current := queuedPoints(data.Queue)
+		result := db.AtomicResult{
+			Exists:          exists,
+			Allowed:         current < rl.opts.Points,
+			RemainingPoints: max(rl.opts.Points-current, 0),
+			ConsumedPoints:  current,
+		}
+		if !result.Allowed {
+			result.MsBeforeNext = ceilMilliseconds(rl.leakyBucketDelay(&data, current+1-rl.opts.Points, now))
+		}
+		return result, true
+	}
+
+	return op
+}
+
+// adjustSlidingWindow records extra requests (penalty) or forgets the most
+// recent ones (reward)
+func (rl *RateLimiter) adjustSlidingWindow(ctx context.Context, kind, key string, points int64) (*Result, error) {
+	var data SlidingWindowData
+	op := rl.newOp(kind, key, "sw", points, &data)
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		if !exists && kind == db.OpReward {
+			return db.AtomicResult{Allowed: true, RemainingPoints: rl.opts.Points}, false
+		}
+
+		now := op.Now
+		data.Requests = rl.removeOldRequests(data.Requests, now.Add(-rl.opts.GetDuration()))
+
+		if kind == db.OpPenalty {
+			if add := min(points, rl.opts.Points-int64(len(data.Requests))); add > 0 {
+				data.Requests = insertRequests(data.Requests, now, add)
+			}
+		} else {
+			// Refund the most recent requests first
+			data.Requests = data.Requests[:int64(len(data.Requests))-min(points, int64(len(data.Requests)))]
+		}
+
+		count := int64(len(data.Requests))
+		result := db.AtomicResult{
+			Exists:          exists,
+			Allowed:         count < rl.opts.Points,
+			RemainingPoints: max(rl.opts.Points-count, 0),
+			ConsumedPoints:  count,
+		}
+		if !result.Allowed && count > 0 {
+			result.MsBeforeNext = max(data.Requests[0].Add(rl.opts.GetDuration()).Sub(now).Milliseconds(), 0)
+		}
+		return result, true
+	}
+
+	res, err := rl.storage.Atomic(ctx, op)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %s to sliding window: %w", kind, err)
+	}
+
+	return rl.newResult(res), nil
+}
+
+// adjustFixedWindow raises (penalty) or lowers (reward) the count of the current window
+func (rl *RateLimiter) adjustFixedWindow(ctx context.Context, kind, key string, points int64) (*Result, error) {
+	res, err := rl.storage.Atomic(ctx, rl.fixedWindowAdjustOp(kind, key, points))
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %s to fixed window: %w", kind, err)
+	}
+
+	return rl.newResult(res), nil
+}
+
+// fixedWindowAdjustOp builds the penalty, reward and cancel operations of the
+// fixed window
+func (rl *RateLimiter) fixedWindowAdjustOp(kind, key string, points int64) *db.AtomicOp {
+	var data FixedWindowData
+	op := rl.newOp(kind, key, "fw", points, &data)
+
+	windowStart := rl.getWindowStartFixed(op.Now)
+	nextWindow := windowStart.Add(rl.opts.GetDuration())
+	op.TTL = rl.fixedWindowTTL(op.Now, nextWindow)
+
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		msBeforeNext := nextWindow.Sub(op.Now).Milliseconds()
+		currentCount := rl.fixedWindowCount(&data, windowStart)
+
+		if kind != db.OpPenalty && currentCount == 0 {
+			return db.AtomicResult{
+				Allowed:         true,
+				RemainingPoints: rl.opts.Points,
+				MsBeforeNext:    msBeforeNext,
+			}, false
+		}
+
+		// Points counted in a window that is over have nothing to give back
+		if kind == db.OpCancel && !rl.getWindowStartFixed(op.Slot).Equal(windowStart) {
+			return db.AtomicResult{
+				Exists:          true,
+				Allowed:         currentCount < rl.opts.Points,
+				RemainingPoints: max(rl.opts.Points-currentCount, 0),
+				ConsumedPoints:  currentCount,
+				MsBeforeNext:    msBeforeNext,
+			}, false
+		}
+
+		count := max(currentCount-points, 0)
+		if kind == db.OpPenalty {
+			count = max(currentCount, min(currentCount+points, rl.opts.Points))
+		}
+		data.Count = count
+		data.WindowStart = windowStart
+
+		return db.AtomicResult{
+			Exists:          currentCount > 0,
+			Allowed:         count < rl.opts.Points,
+			RemainingPoints: max(rl.opts.Points-count, 0),
+			ConsumedPoints:  count,
+			MsBeforeNext:    msBeforeNext,
+		}, true
+	}
+
+	return op
+}
+
+// adjustSlidingWindowCounter raises (penalty) or lowers (reward) the count of
+// the current window, rewards lowering the previous one once the current one
+// is empty
+func (rl *RateLimiter) adjustSlidingWindowCounter(ctx context.Context, kind, key string, points int64) (*Result, error) {
+	res, err := rl.storage.Atomic(ctx, rl.slidingWindowCounterAdjustOp(kind, key, points))
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %s to sliding window counter: %w", kind, err)
+	}
+
+	return rl.newResult(res), nil
+}
+
+// slidingWindowCounterAdjustOp builds the penalty, reward and cancel
+// operations of the sliding window counter
+func (rl *RateLimiter) slidingWindowCounterAdjustOp(kind, key string, points int64) *db.AtomicOp {
+	var data SlidingWindowCounterData
+	op := rl.newOp(kind, key, "swc", points, &data)
+
+	windowStart := rl.getWindowStartFixed(op.Now)
+	op.TTL = rl.slidingWindowCounterTTL(op.Now, windowStart)
+
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		prev, current := rl.slidingWindowCounts(&data, windowStart)
+		elapsed := op.Now.Sub(windowStart).Milliseconds()
+		found := prev > 0 || current > 0
+
+		if kind != db.OpPenalty && !found {
+			return db.AtomicResult{Allowed: true, RemainingPoints: rl.opts.Points}, false
+		}
+
+		consumedWindow := rl.getWindowStartFixed(op.Slot)
+		switch {
+		case kind == db.OpPenalty:
+			if add := min(points, rl.opts.Points-rl.slidingWindowCounterConsumed(prev, current, elapsed)); add > 0 {
+				current += add
+			}
+		case kind == db.OpCancel && !consumedWindow.Equal(windowStart):
+			// Points consumed in what is now the previous window are given back
+			// from its count, points of older windows no longer count
+			if consumedWindow.Add(rl.opts.GetDuration()).Equal(windowStart) {
+				prev = max(prev-points, 0)
+			}
+		default:
+			fromCurrent := min(points, current)
+			current -= fromCurrent
+			prev = max(prev-(points-fromCurrent), 0)
+		}
+		data.PrevCount, data.Count, data.WindowStart = prev, current, windowStart
+
+		consumed := rl.slidingWindowCounterConsumed(prev, current, elapsed)
+		result := db.AtomicResult{
+			Exists:          found,
+			Allowed:         consumed < rl.opts.Points,
+			RemainingPoints: max(rl.opts.Points-consumed, 0),
+			ConsumedPoints:  consumed,
+		}
+		if !result.Allowed {
+			result.MsBeforeNext = rl.slidingWindowCounterDelay(prev, current, 1, elapsed)
+		}
+		return result, true
+	}
+
+	return op
+}
+
+// cancel undoes an allowed consume of points, identified by the slot of its
+// result. The leaky bucket removes the request queued for the slot, so the
+// requests queued behind it keep their places. The window strategies give
+// the points back only to the window they were consumed in, not to a window
+// started since
+func (rl *RateLimiter) cancel(ctx context.Context, key string, points int64, slot time.Time) (*Result, error) {
+	var op *db.AtomicOp
+	switch rl.opts.Strategy {
+	case LeakyBucket:
+		op = rl.leakyBucketAdjustOp(db.OpCancel, key, points)
+	case FixedWindow:
+		op = rl.fixedWindowAdjustOp(db.OpCancel, key, points)
+	case SlidingWindowCounter:
+		op = rl.slidingWindowCounterAdjustOp(db.OpCancel, key, points)
+	default:
+		return nil, fmt.Errorf("%s cannot cancel consumes", rl.strategyName())
+	}
+	op.Slot = slot
+
+	res, err := rl.storage.Atomic(ctx, op)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel %s consume: %w", rl.strategyName(), err)
+	}
+
+	return rl.newResult(res), nil
+}
+
+// Reserve implementations
+//
+// A reservation (kind db.OpReserve) takes points whether or not they are
+// available yet, as long as they become available within one window. When the
+// points are reserved the result reports Allowed=true with MsBeforeNext set
+// to the delay before they may be used. Otherwise nothing is taken and
+// MsBeforeNext is the delay before the reservation fits.
+
+// tokenBucketReserveOp lets the bucket go up to one capacity below zero
+func (rl *RateLimiter) tokenBucketReserveOp(key string, points int64) *db.AtomicOp {
+	var data TokenBucketData
+	op := rl.newOp(db.OpReserve, key, "tb", points, &data)
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		now := op.Now
+
+		if data.LastRefill.IsZero() {
+			data.Capacity = rl.opts.Points
+			data.RefillRate = float64(rl.opts.Points) / rl.opts.GetDuration().Seconds()
+			data.Tokens = float64(rl.opts.Points)
+			data.LastRefill = now
+		}
+
+		elapsed := max(now.Sub(data.LastRefill), 0).Seconds()
+		data.Tokens = math.Min(float64(data.Capacity), data.Tokens+elapsed*data.RefillRate)
+		data.LastRefill = now
+
+		if data.Tokens-float64(points) < -float64(data.Capacity) {
+			return db.AtomicResult{
+				Exists:         exists,
+				MsBeforeNext:   int64(math.Ceil((float64(points-data.Capacity) - data.Tokens) / data.RefillRate * 1000)),
+				ConsumedPoints: data.Capacity - int64(math.Floor(data.Tokens)),
+			}, false
+		}
+
+		data.Tokens -= float64(points)
+		remaining := int64(math.Floor(data.Tokens))
+		result := db.AtomicResult{
+			Exists:          exists,
+			Allowed:         true,
+			RemainingPoints: max(remaining, 0),
+			ConsumedPoints:  data.Capacity - remaining,
+		}
+		if data.Tokens < 0 {
+			result.MsBeforeNext = int64(math.Ceil(-data.Tokens / data.RefillRate * 1000))
+		}
+		return result, true
+	}
+
+	return op
+}
+
+// leakyBucketReserveOp queues up to twice the capacity, the points beyond it
+// waiting for the bucket to drain
+func (rl *RateLimiter) leakyBucketReserveOp(key string, points int64) *db.AtomicOp {
+	var data LeakyBucketData
+	op := rl.newOp(db.OpReserve, key, "lb", points, &data)
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		now := op.Now
+
+		if data.LastDrain.IsZero() {
+			data.DrainRate = float64(rl.opts.Points) / rl.opts.GetDuration().Seconds()
+			data.LastDrain = now
+			data.Queue = make([]QueuedRequest, 0)
+		}
+
+		rl.drainLeakyBucket(&data, now)
+
+		current := queuedPoints(data.Queue)
+		if current+points > 2*rl.opts.Points {
+			return db.AtomicResult{
+				Exists:         exists,
+				MsBeforeNext:   ceilMilliseconds(rl.leakyBucketDelay(&data, current+points-2*rl.opts.Points, now)),
+				ConsumedPoints: current,
+			}, false
+		}
+
+		data.Queue = append(data.Queue, QueuedRequest{Timestamp: now, Points: points})
+		current += points
+
+		result := db.AtomicResult{
+			Exists:          exists,
+			Allowed:         true,
+			RemainingPoints: max(rl.opts.Points-current, 0),
+			ConsumedPoints:  current,
+		}
+		if current > rl.opts.Points {
+			result.MsBeforeNext = ceilMilliseconds(rl.leakyBucketDelay(&data, current-rl.opts.Points, now))
+		}
+		return result, true
+	}
+
+	return op
+}
+
+// slidingWindowReserveOp records the reserved requests at the time they fit
+// in the window, at most one window ahead
+func (rl *RateLimiter) slidingWindowReserveOp(key string, points int64) *db.AtomicOp {
+	var data SlidingWindowData
+	op := rl.newOp(db.OpReserve, key, "sw", points, &data)
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		now := op.Now
+		window := rl.opts.GetDuration()
+		data.Requests = rl.removeOldRequests(data.Requests, now.Add(-window))
+
+		count := int64(len(data.Requests))
+		if count+points > 2*rl.opts.Points {
+			return db.AtomicResult{
+				Exists:         exists,
+				MsBeforeNext:   ceilMilliseconds(data.Requests[count+points-2*rl.opts.Points-1].Add(window).Sub(now)),
+				ConsumedPoints: count,
+			}, false
+		}
+
+		// The reservation fits once enough of the oldest requests have left the
+		// window, which must happen within one window
+		slot := now
+		if count+points > rl.opts.Points {
+			oldest := data.Requests[count+points-rl.opts.Points-1]
+			if oldest.After(now) {
+				return db.AtomicResult{
+					Exists:         exists,
+					MsBeforeNext:   ceilMilliseconds(oldest.Sub(now)),
+					ConsumedPoints: count,
+				}, false
+			}
+			if expires := oldest.Add(window); expires.After(slot) {
+				slot = expires
+			}
+		}
+		data.Requests = insertRequests(data.Requests, slot, points)
+		count += points
+
+		return db.AtomicResult{
+			Exists:          exists,
+			Allowed:         true,
+			RemainingPoints: max(rl.opts.Points-count, 0),
+			ConsumedPoints:  count,
+			MsBeforeNext:    ceilMilliseconds(slot.Sub(now)),
+		}, true
+	}
+
+	return op
+}
+
+// fixedWindowReserveOp counts up to twice the limit in the current window,
+// the points beyond it being carried over to the next window
+func (rl *RateLimiter) fixedWindowReserveOp(key string, points int64) *db.AtomicOp {
+	var data FixedWindowData
+	op := rl.newOp(db.OpReserve, key, "fw", points, &data)
+
+	windowStart := rl.getWindowStartFixed(op.Now)
+	nextWindow := windowStart.Add(rl.opts.GetDuration())
+	op.TTL = rl.fixedWindowTTL(op.Now, nextWindow)
+
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		msBeforeNext := nextWindow.Sub(op.Now).Milliseconds()
+		currentCount := rl.fixedWindowCount(&data, windowStart)
+
+		if currentCount+points > 2*rl.opts.Points {
+			return db.AtomicResult{
+				Exists:          currentCount > 0,
+				RemainingPoints: max(rl.opts.Points-currentCount, 0),
+				ConsumedPoints:  currentCount,
+				MsBeforeNext:    msBeforeNext,
+			}, false
+		}
+
+		data.Count = currentCount + points
+		data.WindowStart = windowStart
+
+		result := db.AtomicResult{
+			Exists:            currentCount > 0,
+			Allowed:           true,
+			RemainingPoints:   max(rl.opts.Points-data.Count, 0),
+			ConsumedPoints:    data.Count,
+			IsFirstInDuration: currentCount == 0,
+		}
+		if data.Count > rl.opts.Points {
+			result.MsBeforeNext = msBeforeNext
+		}
+		return result, true
+	}
+
+	return op
+}
+
+// slidingWindowCounterReserveOp counts up to twice the limit in the current
+// window. Points that fit in it wait for the previous window to weigh little
+// enough, the points beyond it are carried over to the next window
+func (rl *RateLimiter) slidingWindowCounterReserveOp(key string, points int64) *db.AtomicOp {
+	var data SlidingWindowCounterData
+	op := rl.newOp(db.OpReserve, key, "swc", points, &data)
+
+	windowStart := rl.getWindowStartFixed(op.Now)
+	op.TTL = rl.slidingWindowCounterTTL(op.Now, windowStart)
+
+	op.Apply = func(exists bool) (db.AtomicResult, bool) {
+		prev, current := rl.slidingWindowCounts(&data, windowStart)
+		elapsed := op.Now.Sub(windowStart).Milliseconds()
+		delay := rl.slidingWindowCounterDelay(prev, current, points, elapsed)
+		if current+points > rl.opts.Points {
+			delay = rl.opts.GetDuration().Milliseconds() - elapsed
+		}
+
+		if current+points > 2*rl.opts.Points {
+			consumed := rl.slidingWindowCounterConsumed(prev, current, elapsed)
+			return db.AtomicResult{
+				Exists:          prev > 0 || current > 0,
+				MsBeforeNext:    delay,
+				RemainingPoints: max(rl.opts.Points-consumed, 0),
+				ConsumedPoints:  consumed,
+			}, false
+		}
+
+		data.PrevCount, data.Count, data.WindowStart = prev, current+points, windowStart
+		consumed := rl.slidingWindowCounterConsumed(prev, data.Count, elapsed)
+
+		return db.AtomicResult{
+			Exists:            prev > 0 || current > 0,
+			Allowed:           true,
+			RemainingPoints:   max(rl.opts.Points-consumed, 0),
+			ConsumedPoints:    consumed,
+			MsBeforeNext:      delay,
+			IsFirstInDuration: prev == 0 && current == 0,
+		}, true
+	}
+
+	return op
+}
+
+// newOp builds the atomic operation of the configured strategy for key,
+// whose state is stored under the strategy-specific suffix
+func (rl *RateLimiter) newOp(kind, key, suffix string, points int64, state interface{}) *db.AtomicOp {
+	op := &db.AtomicOp{}
+	rl.initOp(op, kind, key, suffix, points, state)
+	return op
+}
+
+// initOp sets op up like newOp, keeping its Apply func
+func (rl *RateLimiter) initOp(op *db.AtomicOp, kind, key, suffix string, points int64, state interface{}) {
+	stateKey, blockKey := rl.cachedKeys(key, suffix)
+	*op = db.AtomicOp{
+		Kind:     kind,
+		Strategy: string(rl.opts.Strategy),
+		Key:      stateKey,
+		BlockKey: blockKey,
+		Points:   points,
+		Limit:    rl.opts.Points,
+		Window:   rl.opts.GetDuration(),
+		TTL:      rl.opts.GetDuration() * 2,
+		Now:      rl.opts.Clock.Now(),
+		State:    state,
+		Apply:    op.Apply,
+
+		BlockDuration: rl.opts.GetBlockDuration(),
+	}
+}
+
+// newResult converts the outcome of an atomic operation into a Result
+func (rl *RateLimiter) newResult(res *db.AtomicResult) *Result {
+	result := rl.results.get()
+	*result = Result{
+		MsBeforeNext:      res.MsBeforeNext,
+		RemainingPoints:   res.RemainingPoints,
+		ConsumedPoints:    res.ConsumedPoints,
+		IsFirstInDuration: res.IsFirstInDuration,
 		TotalHits:         rl.opts.Points,
-		Allowed:           allowed,
+		Allowed:           res.Allowed,
+
+		headerStyle: rl.opts.HeaderStyle,
+		window:      rl.opts.GetDuration(),
+		resetAt:     rl.opts.Clock.Now().Add(time.Duration(res.MsBeforeNext) * time.Millisecond),
 	}
-	
-	return result, nil
+	return result
 }
 
 // Helper functions
 
-// drainRequests removes the specified number of requests from the queue
-func (rl *RateLimiter) drainRequests(queue []QueuedRequest, requestsToDrain int64) []QueuedRequest {
-	pointsDrained := int64(0)
-	drainIndex := 0
-	
-	for i, req := range queue {
-		if pointsDrained >= requestsToDrain {
+// drainLeakyBucket removes the points drained since LastDrain from the front
+// of the queue. Only whole points are drained, the time spent on a partly
+// drained point carries over to the next call
+func (rl *RateLimiter) drainLeakyBucket(data *LeakyBucketData, now time.Time) {
+	drained := int64(max(now.Sub(data.LastDrain), 0).Seconds() * data.DrainRate)
+	if drained >= queuedPoints(data.Queue) {
+		data.Queue = make([]QueuedRequest, 0)
+		data.LastDrain = now
+		return
+	}
+	if drained <= 0 {
+		return
+	}
+
+	data.Queue = rl.drainRequests(data.Queue, drained)
+	data.LastDrain = data.LastDrain.Add(time.Duration(math.Round(float64(drained) / data.DrainRate * float64(time.Second))))
+}
+
+// drainRequests removes the specified number of points from the front of the
+// queue, leaving the rest of a partly drained request queued
+func (rl *RateLimiter) drainRequests(queue []QueuedRequest, pointsToDrain int64) []QueuedRequest {
+	for len(queue) > 0 && pointsToDrain > 0 {
+		if queue[0].Points > pointsToDrain {
+			queue[0].Points -= pointsToDrain
 			break
 		}
-		pointsDrained += req.Points
-		drainIndex = i + 1
+		pointsToDrain -= queue[0].Points
+		queue = queue[1:]
 	}
-	
-	if drainIndex >= len(queue) {
-		return make([]QueuedRequest, 0)
+
+	return queue
+}
+
+// leakyBucketDelay returns how long it takes the given number of queued points
+// to drain. A request queued behind them waits that long before it is
+// processed, so queued requests are spaced evenly at the drain rate
+func (rl *RateLimiter) leakyBucketDelay(data *LeakyBucketData, queued int64, now time.Time) time.Duration {
+	delay := time.Duration(math.Round(float64(queued)/data.DrainRate*float64(time.Second))) - now.Sub(data.LastDrain)
+	return max(delay, 0)
+}
+
+// queuedPoints returns the number of points waiting in a leaky bucket queue
+func queuedPoints(queue []QueuedRequest) int64 {
+	total := int64(0)
+	for _, req := range queue {
+		total += req.Points
 	}
-	
-	return queue[drainIndex:]
+	return total
 }
 
 // removeOldRequests removes requests that are outside the sliding window
@@ -339,9 +995,101 @@ func (rl *RateLimiter) removeOldRequests(requests []time.Time, windowStart time.
 	return validRequests
 }
 
+// fixedWindowCount returns the count of the window starting at windowStart.
+// Points reserved beyond the limit of a window are carried over to the
+// following windows, one limit per window
+func (rl *RateLimiter) fixedWindowCount(data *FixedWindowData, windowStart time.Time) int64 {
+	if data.WindowStart.Equal(windowStart) {
+		return data.Count
+	}
+	if data.WindowStart.IsZero() || data.WindowStart.After(windowStart) {
+		return 0
+	}
+
+	elapsed := int64(windowStart.Sub(data.WindowStart) / rl.opts.GetDuration())
+	return max(data.Count-elapsed*rl.opts.Points, 0)
+}
+
+// fixedWindowTTL keeps fixed window state until the end of the window after
+// the current one, which is as far as reserved points carry over
+func (rl *RateLimiter) fixedWindowTTL(now, nextWindow time.Time) time.Duration {
+	return nextWindow.Sub(now) + rl.opts.GetDuration()
+}
+
+// slidingWindowCounts returns the counts of the previous window and of the
+// window starting at windowStart. Points reserved beyond the limit of a
+// window are carried over to the following windows, one limit per window
+func (rl *RateLimiter) slidingWindowCounts(data *SlidingWindowCounterData, windowStart time.Time) (prev, current int64) {
+	if data.WindowStart.Equal(windowStart) {
+		return data.PrevCount, data.Count
+	}
+	if data.WindowStart.IsZero() || data.WindowStart.After(windowStart) {
+		return 0, 0
+	}
+
+	elapsed := int64(windowStart.Sub(data.WindowStart) / rl.opts.GetDuration())
+	prev = min(max(data.Count-(elapsed-1)*rl.opts.Points, 0), rl.opts.Points)
+	current = min(max(data.Count-elapsed*rl.opts.Points, 0), rl.opts.Points)
+	return prev, current
+}
+
+// slidingWindowCounterTTL keeps sliding window counter state until the end of
+// the second window after the current one: the next window weighs the current
+// count, and points carried over to it are weighed by the window after
+func (rl *RateLimiter) slidingWindowCounterTTL(now, windowStart time.Time) time.Duration {
+	return windowStart.Add(3 * rl.opts.GetDuration()).Sub(now)
+}
+
+// slidingWindowCounterConsumed returns the points consumed in the sliding
+// window ending elapsed milliseconds into the current window, rounded up.
+// Counts are weighted in points times milliseconds to stay exact
+func (rl *RateLimiter) slidingWindowCounterConsumed(prev, current, elapsed int64) int64 {
+	window := rl.opts.GetDuration().Milliseconds()
+	return (prev*(window-elapsed) + current*window + window - 1) / window
+}
+
+// slidingWindowCounterDelay returns the milliseconds before points fit in the
+// sliding window ending elapsed milliseconds into the current window
+func (rl *RateLimiter) slidingWindowCounterDelay(prev, current, points, elapsed int64) int64 {
+	limit, window := rl.opts.Points, rl.opts.GetDuration().Milliseconds()
+	switch {
+	case prev*(window-elapsed)+(current+points)*window <= limit*window:
+		return 0
+	case points > limit:
+		return window - elapsed
+	case current+points <= limit:
+		// The previous window weighs less as time passes
+		return window - (limit-points-current)*window/prev - elapsed
+	default:
+		// The current window has to become the previous one first
+		return window - elapsed + window - (limit-points)*window/current
+	}
+}
+
+// insertRequests adds count requests made at ts to the sliding window log,
+// keeping it sorted when reserved requests are already recorded after ts
+func insertRequests(requests []time.Time, ts time.Time, count int64) []time.Time {
+	i := len(requests)
+	for i > 0 && requests[i-1].After(ts) {
+		i--
+	}
+
+	inserted := make([]time.Time, 0, len(requests)+int(count))
+	inserted = append(inserted, requests[:i]...)
+	for j := int64(0); j < count; j++ {
+		inserted = append(inserted, ts)
+	}
+	return append(inserted, requests[i:]...)
+}
+
+// ceilMilliseconds converts d to milliseconds, rounding up so that callers
+// waiting that long are never early
+func ceilMilliseconds(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
 // getWindowStartFixed returns the start time for fixed window strategy
-func (rl *RateLimiter) getWindowStartFixed() time.Time {
-	now := time.Now()
+func (rl *RateLimiter) getWindowStartFixed(now time.Time) time.Time {
 	duration := rl.opts.GetDuration()
 	return now.Truncate(duration)
 } 
\ No newline at end of file
@@ -0,0 +1,149 @@
+package memcached_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/storagetest"
+	"github.com/veyselaksin/strigo/v2/tests/helpers"
+)
+
+func TestMemcachedStorageConformance(t *testing.T) {
+	memcachedClient := helpers.NewMemcachedClient()
+	if err := memcachedClient.Ping(); err != nil {
+		t.Skip("Memcached not available, skipping storage conformance tests")
+	}
+	defer helpers.CleanupMemcached(t, memcachedClient)
+
+	storagetest.Run(t, func() strigo.Storage {
+		storage, err := strigo.NewMemcachedStorage(helpers.NewMemcachedClient())
+		require.NoError(t, err)
+		return storage
+	})
+}
+
+func TestMemcachedStorageConformanceBinaryCodec(t *testing.T) {
+	memcachedClient := helpers.NewMemcachedClient()
+	if err := memcachedClient.Ping(); err != nil {
+		t.Skip("Memcached not available, skipping storage conformance tests")
+	}
+	defer helpers.CleanupMemcached(t, memcachedClient)
+
+	storagetest.Run(t, func() strigo.Storage {
+		storage, err := strigo.NewMemcachedStorageWithCodec(helpers.NewMemcachedClient(), strigo.BinaryCodec)
+		require.NoError(t, err)
+		return storage
+	})
+}
+
+// Limiters with their own clients stand in for processes sharing Memcached
+func TestMemcachedConcurrentProcesses(t *testing.T) {
+	memcachedClient := helpers.NewMemcachedClient()
+	if err := memcachedClient.Ping(); err != nil {
+		t.Skip("Memcached not available, skipping concurrency tests")
+	}
+	defer helpers.CleanupMemcached(t, memcachedClient)
+
+	strategies := []strigo.Strategy{
+		strigo.TokenBucket,
+		strigo.LeakyBucket,
+		strigo.SlidingWindow,
+		strigo.FixedWindow,
+		strigo.SlidingWindowCounter,
+	}
+
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			var (
+				wg      sync.WaitGroup
+				mu      sync.Mutex
+				allowed int
+			)
+
+			for p := 0; p < 4; p++ {
+				limiter, err := strigo.New(&strigo.Options{
+					Points:      20,
+					Duration:    60,
+					Strategy:    strategy,
+					KeyPrefix:   "cas_test",
+					StoreClient: helpers.NewMemcachedClient(),
+				})
+				require.NoError(t, err)
+				defer limiter.Close()
+
+				for i := 0; i < 15; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						result, err := limiter.Consume("shared", 1)
+						if !assert.NoError(t, err) {
+							return
+						}
+						if result.Allowed {
+							mu.Lock()
+							allowed++
+							mu.Unlock()
+						}
+					}()
+				}
+			}
+			wg.Wait()
+
+			assert.Equal(t, 20, allowed, "concurrent processes must never be over-admitted")
+		})
+	}
+}
+
+// Limits survive switching the codec, states written by either codec are
+// read by the other
+func TestMemcachedCodecSwitchKeepsState(t *testing.T) {
+	memcachedClient := helpers.NewMemcachedClient()
+	if err := memcachedClient.Ping(); err != nil {
+		t.Skip("Memcached not available, skipping codec tests")
+	}
+	defer helpers.CleanupMemcached(t, memcachedClient)
+
+	strategies := []strigo.Strategy{
+		strigo.TokenBucket,
+		strigo.LeakyBucket,
+		strigo.SlidingWindow,
+		strigo.FixedWindow,
+		strigo.SlidingWindowCounter,
+	}
+
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			newLimiter := func(codec strigo.Codec) *strigo.RateLimiter {
+				limiter, err := strigo.New(&strigo.Options{
+					Points:      5,
+					Duration:    60,
+					Strategy:    strategy,
+					KeyPrefix:   "codec_test",
+					StoreClient: helpers.NewMemcachedClient(),
+					Codec:       codec,
+				})
+				require.NoError(t, err)
+				t.Cleanup(func() { limiter.Close() })
+				return limiter
+			}
+			jsonLimiter := newLimiter(strigo.JSONCodec)
+			binaryLimiter := newLimiter(strigo.BinaryCodec)
+
+			result, err := jsonLimiter.Consume("user", 2)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), result.RemainingPoints)
+
+			result, err = binaryLimiter.Consume("user", 2)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), result.RemainingPoints, "binary codec must read the JSON state")
+
+			result, err = jsonLimiter.Consume("user", 2)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed, "JSON codec must read the binary state")
+			assert.Equal(t, int64(1), result.RemainingPoints)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+)
+
+var allStrategies = []strigo.Strategy{
+	strigo.TokenBucket,
+	strigo.LeakyBucket,
+	strigo.SlidingWindow,
+	strigo.FixedWindow,
+	strigo.SlidingWindowCounter,
+}
+
+func TestBlockDeniesConsume(t *testing.T) {
+	for _, strategy := range allStrategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			limiter, err := strigo.New(&strigo.Options{
+				Points:   10,
+				Duration: 60,
+				Strategy: strategy,
+			})
+			require.NoError(t, err)
+			defer limiter.Close()
+
+			result, err := limiter.Consume("user1", 1)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			require.NoError(t, limiter.Block("user1", 30))
+
+			result, err = limiter.Consume("user1", 1)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Greater(t, result.MsBeforeNext, int64(29000))
+			assert.LessOrEqual(t, result.MsBeforeNext, int64(30000))
+
+			status, err := limiter.Get("user1")
+			require.NoError(t, err)
+			require.NotNil(t, status)
+			assert.False(t, status.Allowed)
+			assert.Greater(t, status.MsBeforeNext, int64(29000))
+
+			// Other keys are unaffected
+			result, err = limiter.Consume("user2", 1)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+		})
+	}
+}
+
+func TestBlockWithoutPriorState(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{Points: 5, Duration: 60})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	require.NoError(t, limiter.Block("fresh", 10))
+
+	status, err := limiter.Get("fresh")
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	assert.False(t, status.Allowed)
+}
+
+func TestResetLiftsBlock(t *testing.T) {
+	for _, strategy := range allStrategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			limiter, err := strigo.New(&strigo.Options{
+				Points:   5,
+				Duration: 60,
+				Strategy: strategy,
+			})
+			require.NoError(t, err)
+			defer limiter.Close()
+
+			require.NoError(t, limiter.Block("user1", 60))
+			require.NoError(t, limiter.Reset("user1"))
+
+			result, err := limiter.Consume("user1", 1)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+		})
+	}
+}
+
+func TestBlockRejectsNonPositiveDuration(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{Points: 5, Duration: 60})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	err = limiter.Block("user1", 0)
+	assert.Error(t, err)
+}
+
+func TestBlockDurationAfterExhaustion(t *testing.T) {
+	for _, strategy := range allStrategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			limiter, err := strigo.New(&strigo.Options{
+				Points:        2,
+				Duration:      1,
+				BlockDuration: 3,
+				Strategy:      strategy,
+			})
+			require.NoError(t, err)
+			defer limiter.Close()
+
+			for i := 0; i < 2; i++ {
+				result, err := limiter.Consume("login:alice", 1)
+				require.NoError(t, err)
+				assert.True(t, result.Allowed)
+			}
+
+			// Overshooting the points blocks the key for BlockDuration
+			result, err := limiter.Consume("login:alice", 1)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Equal(t, int64(3000), result.MsBeforeNext)
+
+			// The block outlives the strategy window
+			time.Sleep(1100 * time.Millisecond)
+
+			result, err = limiter.Consume("login:alice", 1)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Greater(t, result.MsBeforeNext, int64(1000))
+			assert.LessOrEqual(t, result.MsBeforeNext, int64(2000))
+		})
+	}
+}
+
+func TestNoAutomaticBlockByDefault(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{Points: 1, Duration: 1, Strategy: strigo.FixedWindow})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	_, err = limiter.Consume("user1", 1)
+	require.NoError(t, err)
+
+	result, err := limiter.Consume("user1", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.LessOrEqual(t, result.MsBeforeNext, int64(1000))
+}
+
+func TestNegativeBlockDurationRejected(t *testing.T) {
+	_, err := strigo.New(&strigo.Options{Points: 5, Duration: 60, BlockDuration: -1})
+	assert.Error(t, err)
+}
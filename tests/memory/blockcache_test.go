@@ -0,0 +1,214 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/clocktest"
+)
+
+func newBlockCacheLimiter(t *testing.T, opts *strigo.Options) (*strigo.RateLimiter, *flakyStorage, *clocktest.Clock) {
+	clock := clocktest.New(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	storage := &flakyStorage{Storage: strigo.NewMemoryStorageWithClock(clock)}
+	opts.Store = storage
+	opts.Clock = clock
+
+	limiter, err := strigo.New(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { limiter.Close() })
+	return limiter, storage, clock
+}
+
+func TestBlockCacheSkipsStorage(t *testing.T) {
+	limiter, storage, clock := newBlockCacheLimiter(t, &strigo.Options{
+		Points:         2,
+		Duration:       10,
+		Strategy:       strigo.FixedWindow,
+		BlockCacheSize: 10,
+	})
+
+	consume(t, limiter, 2)
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	calls := storage.calls.Load()
+
+	for i := 0; i < 100; i++ {
+		cached, err := limiter.Consume("user", 1)
+		require.NoError(t, err)
+		assert.False(t, cached.Allowed)
+		assert.Equal(t, int64(0), cached.RemainingPoints)
+		assert.Equal(t, int64(2), cached.TotalHits)
+		assert.LessOrEqual(t, cached.MsBeforeNext, result.MsBeforeNext)
+	}
+	assert.Equal(t, calls, storage.calls.Load(), "denied keys must not reach the storage")
+
+	// Other keys are not affected
+	other, err := limiter.Consume("other", 1)
+	require.NoError(t, err)
+	assert.True(t, other.Allowed)
+
+	clock.Advance(time.Duration(result.MsBeforeNext) * time.Millisecond)
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "the key must reach the storage again once the window passed")
+}
+
+func TestBlockCacheKeepsPartialDenials(t *testing.T) {
+	limiter, storage, _ := newBlockCacheLimiter(t, &strigo.Options{
+		Points:         5,
+		Duration:       10,
+		BlockCacheSize: 10,
+	})
+
+	// A request larger than the remaining points leaves points for smaller ones
+	result, err := limiter.Consume("user", 10)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+
+	calls := storage.calls.Load()
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, calls+1, storage.calls.Load())
+}
+
+// A denial only stands for requests at least as large as the denied one
+func TestBlockCacheMixedPoints(t *testing.T) {
+	limiter, storage, clock := newBlockCacheLimiter(t, &strigo.Options{
+		Points:         5,
+		Duration:       10,
+		Strategy:       strigo.TokenBucket,
+		BlockCacheSize: 10,
+	})
+
+	consume(t, limiter, 5)
+	result, err := limiter.Consume("user", 5)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	calls := storage.calls.Load()
+
+	result, err = limiter.Consume("user", 5)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, calls, storage.calls.Load(), "requests as large as the denied one are denied from the cache")
+
+	// 1.5 tokens were refilled, enough for a small request
+	clock.Advance(3 * time.Second)
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "smaller requests must reach the storage")
+	assert.Greater(t, storage.calls.Load(), calls)
+}
+
+func TestBlockCacheResetAndReward(t *testing.T) {
+	limiter, _, _ := newBlockCacheLimiter(t, &strigo.Options{
+		Points:         1,
+		Duration:       60,
+		BlockCacheSize: 10,
+	})
+
+	consume(t, limiter, 1)
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+
+	require.NoError(t, limiter.Reset("user"))
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "reset must clear the cached denial")
+
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+
+	_, err = limiter.Reward("user", 1)
+	require.NoError(t, err)
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "reward must clear the cached denial")
+}
+
+func TestBlockCacheBlock(t *testing.T) {
+	limiter, storage, clock := newBlockCacheLimiter(t, &strigo.Options{
+		Points:         5,
+		Duration:       1,
+		BlockCacheSize: 10,
+	})
+
+	require.NoError(t, limiter.Block("user", 30))
+	calls := storage.calls.Load()
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(30000), result.MsBeforeNext)
+	assert.Equal(t, calls, storage.calls.Load())
+
+	clock.Advance(30 * time.Second)
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestBlockCacheEviction(t *testing.T) {
+	limiter, storage, _ := newBlockCacheLimiter(t, &strigo.Options{
+		Points:         1,
+		Duration:       60,
+		BlockCacheSize: 2,
+	})
+
+	deny := func(key string) {
+		_, err := limiter.Consume(key, 1)
+		require.NoError(t, err)
+		result, err := limiter.Consume(key, 1)
+		require.NoError(t, err)
+		require.False(t, result.Allowed)
+	}
+	deny("a")
+	deny("b")
+	deny("c") // evicts "a", the least recently used key
+
+	calls := storage.calls.Load()
+	for _, key := range []string{"b", "c"} {
+		_, err := limiter.Consume(key, 1)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, calls, storage.calls.Load())
+
+	_, err := limiter.Consume("a", 1)
+	require.NoError(t, err)
+	assert.Equal(t, calls+1, storage.calls.Load(), "evicted keys must reach the storage")
+}
+
+func TestInvalidBlockCacheSize(t *testing.T) {
+	_, err := strigo.New(&strigo.Options{Points: 1, Duration: 1, BlockCacheSize: -1})
+	assert.Error(t, err)
+}
+
+func TestBlockCacheConsumeMany(t *testing.T) {
+	limiter, storage, _ := newBlockCacheLimiter(t, &strigo.Options{
+		Points:         1,
+		Duration:       60,
+		BlockCacheSize: 10,
+	})
+
+	results, err := limiter.ConsumeMany([]string{"a", "a", "b"}, 1)
+	require.NoError(t, err)
+	assert.False(t, results[1].Allowed)
+
+	// Only "b" reaches the storage, "a" is denied from the cache
+	calls := storage.calls.Load()
+	results, err = limiter.ConsumeMany([]string{"a", "b"}, 1)
+	require.NoError(t, err)
+	assert.False(t, results[0].Allowed)
+	assert.False(t, results[1].Allowed)
+	assert.Equal(t, calls+1, storage.calls.Load())
+
+	results, err = limiter.ConsumeMany(nil)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
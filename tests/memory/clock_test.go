@@ -0,0 +1,154 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/clocktest"
+)
+
+func newClockLimiter(t *testing.T, opts *strigo.Options) (*strigo.RateLimiter, *clocktest.Clock) {
+	clock := clocktest.New(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	opts.Clock = clock
+
+	limiter, err := strigo.New(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { limiter.Close() })
+	return limiter, clock
+}
+
+func consume(t *testing.T, limiter *strigo.RateLimiter, points int64) *strigo.Result {
+	result, err := limiter.Consume("user", points)
+	require.NoError(t, err)
+	return result
+}
+
+func TestClockTokenBucketRefill(t *testing.T) {
+	limiter, clock := newClockLimiter(t, &strigo.Options{Points: 10, Duration: 10, Strategy: strigo.TokenBucket})
+
+	assert.True(t, consume(t, limiter, 10).Allowed)
+	assert.False(t, consume(t, limiter, 1).Allowed)
+
+	// One token per second
+	clock.Advance(3 * time.Second)
+	assert.True(t, consume(t, limiter, 3).Allowed)
+	assert.False(t, consume(t, limiter, 1).Allowed)
+}
+
+func TestClockLeakyBucketDrain(t *testing.T) {
+	limiter, clock := newClockLimiter(t, &strigo.Options{Points: 5, Duration: 5, Strategy: strigo.LeakyBucket})
+
+	for i := 0; i < 5; i++ {
+		assert.True(t, consume(t, limiter, 1).Allowed)
+	}
+	assert.False(t, consume(t, limiter, 1).Allowed)
+
+	clock.Advance(2 * time.Second)
+	assert.True(t, consume(t, limiter, 1).Allowed)
+	assert.True(t, consume(t, limiter, 1).Allowed)
+	assert.False(t, consume(t, limiter, 1).Allowed)
+}
+
+func TestClockSlidingWindow(t *testing.T) {
+	limiter, clock := newClockLimiter(t, &strigo.Options{Points: 2, Duration: 60, Strategy: strigo.SlidingWindow})
+
+	assert.True(t, consume(t, limiter, 1).Allowed)
+	clock.Advance(30 * time.Second)
+	assert.True(t, consume(t, limiter, 1).Allowed)
+
+	result := consume(t, limiter, 1)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(30000), result.MsBeforeNext)
+
+	// The first request leaves the window
+	clock.Advance(30*time.Second + time.Millisecond)
+	assert.True(t, consume(t, limiter, 1).Allowed)
+	assert.False(t, consume(t, limiter, 1).Allowed)
+}
+
+func TestClockSlidingWindowCounter(t *testing.T) {
+	limiter, clock := newClockLimiter(t, &strigo.Options{Points: 10, Duration: 60, Strategy: strigo.SlidingWindowCounter})
+
+	assert.True(t, consume(t, limiter, 10).Allowed)
+
+	// The previous window counts in full right after the rollover
+	clock.Advance(60 * time.Second)
+	result := consume(t, limiter, 1)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(6000), result.MsBeforeNext)
+
+	// A quarter into the window, three quarters of it still count
+	clock.Advance(15 * time.Second)
+	result = consume(t, limiter, 2)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(10), result.ConsumedPoints, "7.5 points rounded up")
+	assert.Equal(t, int64(0), result.RemainingPoints)
+
+	result = consume(t, limiter, 1)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(3000), result.MsBeforeNext)
+
+	clock.Advance(3 * time.Second)
+	assert.True(t, consume(t, limiter, 1).Allowed)
+}
+
+func TestClockFixedWindowRollover(t *testing.T) {
+	limiter, clock := newClockLimiter(t, &strigo.Options{Points: 3, Duration: 60, Strategy: strigo.FixedWindow})
+
+	clock.Advance(45 * time.Second)
+	assert.True(t, consume(t, limiter, 3).Allowed)
+
+	result := consume(t, limiter, 1)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(15000), result.MsBeforeNext)
+
+	clock.Advance(15 * time.Second)
+	result = consume(t, limiter, 1)
+	assert.True(t, result.Allowed)
+	assert.True(t, result.IsFirstInDuration)
+}
+
+func TestClockBlockExpiry(t *testing.T) {
+	limiter, clock := newClockLimiter(t, &strigo.Options{Points: 1, Duration: 1, BlockDuration: 600})
+
+	assert.True(t, consume(t, limiter, 1).Allowed)
+	assert.False(t, consume(t, limiter, 1).Allowed)
+
+	clock.Advance(599 * time.Second)
+	result := consume(t, limiter, 1)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(1000), result.MsBeforeNext)
+
+	clock.Advance(time.Second)
+	assert.True(t, consume(t, limiter, 1).Allowed)
+
+	require.NoError(t, limiter.Block("user", 60))
+	clock.Advance(time.Minute)
+	assert.True(t, consume(t, limiter, 1).Allowed)
+}
+
+func TestClockMemoryStorageExpiry(t *testing.T) {
+	clock := clocktest.New(time.Now())
+	limiter, err := strigo.New(&strigo.Options{
+		Points:   1,
+		Duration: 60,
+		Store:    strigo.NewMemoryStorageWithClock(clock),
+		Clock:    clock,
+	})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	assert.True(t, consume(t, limiter, 1).Allowed)
+	status, err := limiter.Get("user")
+	require.NoError(t, err)
+	require.NotNil(t, status)
+
+	// State expires with the fake clock, after twice the duration
+	clock.Advance(2*time.Minute + time.Millisecond)
+	status, err = limiter.Get("user")
+	require.NoError(t, err)
+	assert.Nil(t, status)
+}
@@ -0,0 +1,106 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+)
+
+func codecStates() map[string][2]interface{} {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC)
+
+	// Each entry holds a state and an empty value of its type to decode into
+	return map[string][2]interface{}{
+		"token_bucket": {
+			&strigo.TokenBucketData{Tokens: -2.75, LastRefill: now, Capacity: 100, RefillRate: 1.0 / 3},
+			&strigo.TokenBucketData{},
+		},
+		"leaky_bucket": {
+			&strigo.LeakyBucketData{
+				Queue: []strigo.QueuedRequest{
+					{Timestamp: now.Add(-1500 * time.Millisecond), Points: 3},
+					{Timestamp: now.Add(-time.Millisecond), Points: 1},
+					{Timestamp: now, Points: 2},
+				},
+				LastDrain: now,
+				DrainRate: 0.5,
+			},
+			&strigo.LeakyBucketData{},
+		},
+		"sliding_window": {
+			&strigo.SlidingWindowData{Requests: []time.Time{now.Add(-time.Minute), now, now}},
+			&strigo.SlidingWindowData{},
+		},
+		"fixed_window": {
+			&strigo.FixedWindowData{Count: 42, WindowStart: now},
+			&strigo.FixedWindowData{},
+		},
+		"sliding_window_counter": {
+			&strigo.SlidingWindowCounterData{Count: 7, PrevCount: 1 << 40, WindowStart: now},
+			&strigo.SlidingWindowCounterData{},
+		},
+		"empty": {
+			&strigo.TokenBucketData{},
+			&strigo.TokenBucketData{},
+		},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	codecs := map[string]strigo.Codec{"json": strigo.JSONCodec, "binary": strigo.BinaryCodec}
+
+	for name, state := range codecStates() {
+		for writerName, writer := range codecs {
+			for readerName, reader := range codecs {
+				t.Run(name+"/"+writerName+"_to_"+readerName, func(t *testing.T) {
+					data, err := writer.Marshal(state[0])
+					require.NoError(t, err)
+
+					require.NoError(t, reader.Unmarshal(data, state[1]))
+					assert.Equal(t, state[0], state[1])
+				})
+			}
+		}
+	}
+}
+
+func TestBinaryCodecIsSmaller(t *testing.T) {
+	for name, state := range codecStates() {
+		jsonData, err := strigo.JSONCodec.Marshal(state[0])
+		require.NoError(t, err)
+		binaryData, err := strigo.BinaryCodec.Marshal(state[0])
+		require.NoError(t, err)
+
+		assert.Less(t, len(binaryData), len(jsonData)/2, name)
+	}
+}
+
+func TestBinaryCodecRejectsMalformedState(t *testing.T) {
+	data, err := strigo.BinaryCodec.Marshal(&strigo.LeakyBucketData{
+		Queue:     []strigo.QueuedRequest{{Timestamp: time.Now(), Points: 1}},
+		LastDrain: time.Now(),
+		DrainRate: 1,
+	})
+	require.NoError(t, err)
+
+	var state strigo.LeakyBucketData
+	for i := 1; i < len(data); i++ {
+		assert.Error(t, strigo.BinaryCodec.Unmarshal(data[:i], &state), "truncated to %d bytes", i)
+	}
+	assert.Error(t, strigo.BinaryCodec.Unmarshal(append(data, 0), &state), "trailing bytes")
+
+	// Other layout versions, like the one of the Redis scripts, are not misread
+	for _, version := range []byte{2, 3} {
+		other := append([]byte{data[0], version}, data[2:]...)
+		assert.Error(t, strigo.BinaryCodec.Unmarshal(other, &state), "version %d", version)
+	}
+}
+
+func TestBinaryCodecEncodesOtherValuesAsJSON(t *testing.T) {
+	data, err := strigo.BinaryCodec.Marshal(int64(1700000000000))
+	require.NoError(t, err)
+	assert.Equal(t, "1700000000000", string(data))
+}
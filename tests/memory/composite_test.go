@@ -0,0 +1,172 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/clocktest"
+)
+
+// newComposite limits to 3 per second and 5 per minute
+func newComposite(t *testing.T) (*strigo.Composite, *strigo.RateLimiter, *strigo.RateLimiter, *clocktest.Clock) {
+	clock := clocktest.New(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	perSecond, err := strigo.New(&strigo.Options{Points: 3, Duration: 1, Strategy: strigo.FixedWindow, Clock: clock})
+	require.NoError(t, err)
+	t.Cleanup(func() { perSecond.Close() })
+
+	perMinute, err := strigo.New(&strigo.Options{Points: 5, Duration: 60, Strategy: strigo.FixedWindow, Clock: clock})
+	require.NoError(t, err)
+	t.Cleanup(func() { perMinute.Close() })
+
+	composite, err := strigo.NewComposite(perSecond, perMinute)
+	require.NoError(t, err)
+	return composite, perSecond, perMinute, clock
+}
+
+func TestCompositeMostRestrictiveResult(t *testing.T) {
+	composite, _, _, clock := newComposite(t)
+
+	result, err := composite.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(2), result.RemainingPoints)
+	assert.Equal(t, int64(3), result.TotalHits, "the per-second limit has the fewest points left")
+
+	_, err = composite.Consume("user", 2)
+	require.NoError(t, err)
+
+	result, err = composite.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(3), result.TotalHits)
+	assert.LessOrEqual(t, result.MsBeforeNext, int64(1000))
+
+	clock.Advance(time.Second)
+	result, err = composite.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(1), result.RemainingPoints)
+	assert.Equal(t, int64(5), result.TotalHits, "the per-minute limit is now the most restrictive")
+
+	status, err := composite.Get("user")
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	assert.Equal(t, int64(5), status.TotalHits)
+	assert.Equal(t, int64(1), status.RemainingPoints)
+}
+
+func TestCompositeRefundsOnDenial(t *testing.T) {
+	composite, perSecond, perMinute, clock := newComposite(t)
+
+	// Spend the per-minute limit over two seconds
+	_, err := composite.Consume("user", 3)
+	require.NoError(t, err)
+	clock.Advance(time.Second)
+	_, err = composite.Consume("user", 2)
+	require.NoError(t, err)
+	clock.Advance(time.Second)
+
+	// The per-second limit allows the request, the per-minute one denies it
+	result, err := composite.Consume("user", 2)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(5), result.TotalHits)
+
+	state, err := perSecond.Get("user")
+	require.NoError(t, err)
+	assert.Nil(t, state, "the per-second points must be refunded")
+
+	state, err = perMinute.Get("user")
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, int64(0), state.RemainingPoints)
+
+	require.NoError(t, composite.Reset("user"))
+	result, err = composite.Consume("user", 3)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+// hookStorage runs before, when set, ahead of every atomic operation
+type hookStorage struct {
+	strigo.Storage
+	before func()
+}
+
+func (s *hookStorage) Atomic(ctx context.Context, op *strigo.AtomicOp) (*strigo.AtomicResult, error) {
+	if s.before != nil {
+		s.before()
+	}
+	return s.Storage.Atomic(ctx, op)
+}
+
+func TestCompositeRefundAfterWindowRollover(t *testing.T) {
+	for _, strategy := range []strigo.Strategy{strigo.FixedWindow, strigo.SlidingWindowCounter} {
+		t.Run(string(strategy), func(t *testing.T) {
+			clock := clocktest.New(time.Date(2024, 1, 1, 12, 0, 0, 900*int(time.Millisecond), time.UTC))
+
+			perSecond, err := strigo.New(&strigo.Options{Points: 5, Duration: 1, Strategy: strategy, Clock: clock})
+			require.NoError(t, err)
+			defer perSecond.Close()
+
+			store := &hookStorage{Storage: strigo.NewMemoryStorageWithClock(clock)}
+			perMinute, err := strigo.New(&strigo.Options{Points: 1, Duration: 60, Strategy: strigo.FixedWindow, Clock: clock, Store: store})
+			require.NoError(t, err)
+			defer perMinute.Close()
+			_, err = perMinute.Consume("user", 1)
+			require.NoError(t, err)
+
+			composite, err := strigo.NewComposite(perSecond, perMinute)
+			require.NoError(t, err)
+
+			// The next second starts, and 2 points are consumed in it, between
+			// the per-second consume and the per-minute denial
+			store.before = func() {
+				store.before = nil
+				clock.Advance(600 * time.Millisecond)
+				_, err := perSecond.Consume("user", 2)
+				require.NoError(t, err)
+			}
+			result, err := composite.Consume("user", 2)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+
+			state, err := perSecond.Get("user")
+			require.NoError(t, err)
+			require.NotNil(t, state)
+			assert.Equal(t, int64(2), state.ConsumedPoints, "the refund must not lower the count of the new window")
+		})
+	}
+}
+
+func TestCompositeRequiresLimiters(t *testing.T) {
+	_, err := strigo.NewComposite()
+	assert.Error(t, err)
+
+	_, err = strigo.NewComposite(nil)
+	assert.Error(t, err)
+}
+
+func TestCompositeRejectsSharedKeyPrefix(t *testing.T) {
+	store := strigo.NewMemoryStorage()
+	newLimiter := func(prefix string, strategy strigo.Strategy) *strigo.RateLimiter {
+		limiter, err := strigo.New(&strigo.Options{Points: 5, Duration: 60, Strategy: strategy, KeyPrefix: prefix, Store: store})
+		require.NoError(t, err)
+		return limiter
+	}
+	defer store.Close()
+
+	// Same prefix on one store: state and block keys collide
+	_, err := strigo.NewComposite(newLimiter("api", strigo.FixedWindow), newLimiter("api", strigo.FixedWindow))
+	assert.Error(t, err)
+	_, err = strigo.NewComposite(newLimiter("api", strigo.FixedWindow), newLimiter("api", strigo.TokenBucket))
+	assert.Error(t, err, "the block key is shared across strategies")
+
+	_, err = strigo.NewComposite(newLimiter("api:second", strigo.FixedWindow), newLimiter("api:minute", strigo.FixedWindow))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,64 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+)
+
+func TestContextVariants(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{Points: 5, Duration: 60})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	ctx := context.Background()
+
+	result, err := limiter.ConsumeCtx(ctx, "user1", 2)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(3), result.RemainingPoints)
+
+	status, err := limiter.GetCtx(ctx, "user1")
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	assert.Equal(t, int64(3), status.RemainingPoints)
+
+	require.NoError(t, limiter.BlockCtx(ctx, "user1", 10))
+	result, err = limiter.ConsumeCtx(ctx, "user1", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	require.NoError(t, limiter.ResetCtx(ctx, "user1"))
+	status, err = limiter.GetCtx(ctx, "user1")
+	require.NoError(t, err)
+	assert.Nil(t, status)
+}
+
+func TestCancelledContext(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{Points: 5, Duration: 60})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := limiter.ConsumeCtx(ctx, "user1", 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+
+	_, err = limiter.GetCtx(ctx, "user1")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	assert.ErrorIs(t, limiter.BlockCtx(ctx, "user1", 10), context.Canceled)
+	assert.ErrorIs(t, limiter.ResetCtx(ctx, "user1"), context.Canceled)
+	assert.ErrorIs(t, limiter.CloseCtx(ctx), context.Canceled)
+
+	// Nothing was consumed by the cancelled call
+	status, err := limiter.Get("user1")
+	require.NoError(t, err)
+	assert.Nil(t, status)
+
+	require.NoError(t, limiter.CloseCtx(context.Background()))
+}
@@ -0,0 +1,140 @@
+package memory_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/clocktest"
+)
+
+func TestMemoryMaxKeysEvictsLeastRecentlyUsed(t *testing.T) {
+	storage := strigo.NewMemoryStorageWithOptions(strigo.MemoryStorageOptions{MaxKeys: 3})
+	defer storage.Close()
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "c", "a"} {
+		_, err := storage.Increment(ctx, key, 1, time.Minute)
+		require.NoError(t, err)
+	}
+
+	// "b" is the least recently used key now
+	_, err := storage.Increment(ctx, "d", 1, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 3, storage.Len())
+
+	count, err := storage.Get(ctx, "b")
+	require.NoError(t, err)
+	assert.Zero(t, count, "the least recently used key must be evicted")
+
+	count, err = storage.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestMemoryMaxKeysBoundsLimiterKeys(t *testing.T) {
+	storage := strigo.NewMemoryStorageWithOptions(strigo.MemoryStorageOptions{MaxKeys: 1000})
+	limiter, err := strigo.New(&strigo.Options{Points: 10, Duration: 60, BlockDuration: 60, Store: storage})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	for i := 0; i < 10000; i++ {
+		_, err := limiter.Consume(fmt.Sprintf("10.0.%d.%d", i/256, i%256), 11)
+		require.NoError(t, err)
+	}
+	assert.LessOrEqual(t, storage.Len(), 1000)
+	assert.Greater(t, storage.Len(), 500, "evictions must be spread evenly over the shards")
+}
+
+func TestMemoryMaxKeysOption(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{Points: 1, Duration: 60, MemoryMaxKeys: 10})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	consumeKey := func(key string) bool {
+		result, err := limiter.Consume(key)
+		require.NoError(t, err)
+		return result.Allowed
+	}
+
+	assert.True(t, consumeKey("first"))
+	assert.False(t, consumeKey("first"))
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, consumeKey(fmt.Sprintf("other-%d", i)))
+	}
+	assert.True(t, consumeKey("first"), "the state of evicted keys starts over")
+}
+
+// A blocked key that keeps being requested must not be evicted with its block
+func TestMemoryMaxKeysKeepsRequestedBlocks(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{Points: 1, Duration: 60, BlockDuration: 600, MemoryMaxKeys: 64})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	consumeKey := func(key string) bool {
+		result, err := limiter.Consume(key)
+		require.NoError(t, err)
+		return result.Allowed
+	}
+
+	assert.True(t, consumeKey("attacker"))
+	assert.False(t, consumeKey("attacker"))
+
+	for i := 0; i < 200; i++ {
+		consumeKey(fmt.Sprintf("other-%d", i))
+		require.False(t, consumeKey("attacker"), "blocked key allowed after %d other keys", i+1)
+	}
+}
+
+func TestMemoryCleanupInterval(t *testing.T) {
+	clock := clocktest.New(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	storage := strigo.NewMemoryStorageWithOptions(strigo.MemoryStorageOptions{
+		CleanupInterval: 10 * time.Millisecond,
+		Clock:           clock,
+	})
+	defer storage.Close()
+	ctx := context.Background()
+
+	require.NoError(t, storage.SetJSON(ctx, "short", 1, time.Second))
+	require.NoError(t, storage.SetJSON(ctx, "long", 1, time.Hour))
+	assert.Equal(t, 2, storage.Len())
+
+	clock.Advance(2 * time.Second)
+	assert.Eventually(t, func() bool { return storage.Len() == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestMemoryCloseStopsJanitor(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 50; i++ {
+		limiter, err := strigo.New(&strigo.Options{Points: 1, Duration: 1, MemoryCleanupInterval: time.Millisecond})
+		require.NoError(t, err)
+		_, err = limiter.Consume("user")
+		require.NoError(t, err)
+		require.NoError(t, limiter.Close())
+	}
+
+	storage := strigo.NewMemoryStorage()
+	require.NoError(t, storage.Close())
+	require.NoError(t, storage.Close(), "closing twice must not fail")
+
+	// Polled by hand, assert.Eventually runs the condition in a goroutine
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "closed memory stores must not leak their janitor goroutine")
+}
+
+func TestInvalidMemoryOptions(t *testing.T) {
+	_, err := strigo.New(&strigo.Options{Points: 1, Duration: 1, MemoryMaxKeys: -1})
+	assert.Error(t, err)
+
+	_, err = strigo.New(&strigo.Options{Points: 1, Duration: 1, MemoryCleanupInterval: -time.Second})
+	assert.Error(t, err)
+}
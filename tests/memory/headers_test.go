@@ -0,0 +1,95 @@
+package memory_test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+)
+
+func TestHeadersLegacyByDefault(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{Points: 2, Duration: 60})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+
+	headers := result.Headers()
+	assert.Equal(t, "2", headers["X-RateLimit-Limit"])
+	assert.Equal(t, "1", headers["X-RateLimit-Remaining"])
+	assert.NotEmpty(t, headers["X-RateLimit-Reset"])
+	assert.NotContains(t, headers, "RateLimit")
+	assert.NotContains(t, headers, "RateLimit-Policy")
+	assert.NotContains(t, headers, "Retry-After")
+}
+
+func TestHeadersResetUsesLimiterClock(t *testing.T) {
+	limiter, clock := newClockLimiter(t, &strigo.Options{Points: 1, Duration: 60, Strategy: strigo.FixedWindow})
+
+	consume(t, limiter, 1)
+	result := consume(t, limiter, 1)
+	require.False(t, result.Allowed)
+
+	// The window ends at 12:01 by the limiter clock, whatever the time is
+	reset := clock.Now().Truncate(time.Minute).Add(time.Minute)
+	assert.Equal(t, strconv.FormatInt(reset.Unix(), 10), result.Headers()["X-RateLimit-Reset"])
+}
+
+func TestHeadersIETF(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{
+		Points:      2,
+		Duration:    60,
+		HeaderStyle: strigo.HeaderStyleIETF,
+	})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+
+	headers := result.Headers()
+	assert.Equal(t, `"default";q=2;w=60`, headers["RateLimit-Policy"])
+	assert.Equal(t, fmt.Sprintf(`"default";r=1;t=%d`, (result.MsBeforeNext+999)/1000), headers["RateLimit"])
+	assert.NotContains(t, headers, "X-RateLimit-Limit")
+
+	_, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+
+	headers = result.Headers()
+	assert.Equal(t, fmt.Sprintf(`"default";r=0;t=%d`, (result.MsBeforeNext+999)/1000), headers["RateLimit"])
+	assert.NotEmpty(t, headers["Retry-After"])
+}
+
+func TestHeadersFor(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{Points: 10, Duration: 3600})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+
+	headers := result.HeadersFor(strigo.HeaderStyleBoth)
+	assert.Equal(t, "10", headers["X-RateLimit-Limit"])
+	assert.Equal(t, `"default";q=10;w=3600`, headers["RateLimit-Policy"])
+	assert.Contains(t, headers, "RateLimit")
+
+	// A Result built by hand has no window, so w is omitted
+	manual := &strigo.Result{TotalHits: 5, RemainingPoints: 0, MsBeforeNext: 1500}
+	headers = manual.HeadersFor(strigo.HeaderStyleIETF)
+	assert.Equal(t, `"default";q=5`, headers["RateLimit-Policy"])
+	assert.Equal(t, `"default";r=0;t=2`, headers["RateLimit"])
+	assert.Equal(t, "1", headers["Retry-After"])
+}
+
+func TestInvalidHeaderStyle(t *testing.T) {
+	_, err := strigo.New(&strigo.Options{Points: 1, Duration: 1, HeaderStyle: "rfc"})
+	assert.Error(t, err)
+}
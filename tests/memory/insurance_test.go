@@ -0,0 +1,213 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/clocktest"
+)
+
+var errStorageDown = errors.New("storage down")
+
+// flakyStorage is a memory storage whose atomic operations fail while down is set
+type flakyStorage struct {
+	strigo.Storage
+	down  atomic.Bool
+	calls atomic.Int64
+}
+
+func (f *flakyStorage) Atomic(ctx context.Context, op *strigo.AtomicOp) (*strigo.AtomicResult, error) {
+	f.calls.Add(1)
+	if f.down.Load() {
+		return nil, errStorageDown
+	}
+	return f.Storage.Atomic(ctx, op)
+}
+
+func (f *flakyStorage) SetJSON(ctx context.Context, key string, value interface{}, expiry time.Duration) error {
+	if f.down.Load() {
+		return errStorageDown
+	}
+	return f.Storage.SetJSON(ctx, key, value, expiry)
+}
+
+func newInsuredLimiter(t *testing.T, opts *strigo.Options) (*strigo.RateLimiter, *flakyStorage) {
+	insurance, err := strigo.New(&strigo.Options{Points: 2, Duration: 60, Clock: opts.Clock})
+	require.NoError(t, err)
+	t.Cleanup(func() { insurance.Close() })
+
+	storage := &flakyStorage{Storage: strigo.NewMemoryStorage()}
+	opts.Store = storage
+	opts.InsuranceLimiter = insurance
+
+	limiter, err := strigo.New(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { limiter.Close() })
+	return limiter, storage
+}
+
+func TestStorageErrorWithoutInsurance(t *testing.T) {
+	storage := &flakyStorage{Storage: strigo.NewMemoryStorage()}
+	limiter, err := strigo.New(&strigo.Options{Points: 5, Duration: 60, Store: storage})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	storage.down.Store(true)
+	_, err = limiter.Consume("user", 1)
+	assert.ErrorIs(t, err, errStorageDown)
+}
+
+// blockResetFailingStorage is a memory storage that cannot lift blocks
+type blockResetFailingStorage struct {
+	strigo.Storage
+}
+
+func (s blockResetFailingStorage) Reset(ctx context.Context, key string) error {
+	if strings.HasSuffix(key, ":block") {
+		return errStorageDown
+	}
+	return s.Storage.Reset(ctx, key)
+}
+
+func TestResetReportsBlockError(t *testing.T) {
+	storage := blockResetFailingStorage{strigo.NewMemoryStorage()}
+	limiter, err := strigo.New(&strigo.Options{Points: 5, Duration: 60, Store: storage})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	require.NoError(t, limiter.Block("user", 30))
+	assert.ErrorIs(t, limiter.Reset("user"), errStorageDown)
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "the block was not lifted")
+}
+
+func TestInsuranceFailoverAndRecovery(t *testing.T) {
+	var failovers, recoveries atomic.Int64
+	limiter, storage := newInsuredLimiter(t, &strigo.Options{
+		Points:   5,
+		Duration: 60,
+		OnFailover: func(err error) {
+			assert.ErrorIs(t, err, errStorageDown)
+			failovers.Add(1)
+		},
+		OnRecover: func() { recoveries.Add(1) },
+	})
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), result.TotalHits)
+
+	storage.down.Store(true)
+
+	// The insurance limiter allows 2 points
+	for i := 0; i < 2; i++ {
+		result, err = limiter.Consume("user", 1)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, int64(2), result.TotalHits)
+	}
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(1), failovers.Load(), "failover must be reported once")
+
+	storage.down.Store(false)
+
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(5), result.TotalHits)
+	assert.Equal(t, int64(3), result.RemainingPoints, "primary state survives the outage")
+	assert.Equal(t, int64(1), recoveries.Load())
+
+	_, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), recoveries.Load(), "recovery must be reported once")
+}
+
+func TestInsuranceRetryInterval(t *testing.T) {
+	clock := clocktest.New(time.Now())
+	limiter, storage := newInsuredLimiter(t, &strigo.Options{
+		Points:                 5,
+		Duration:               60,
+		Clock:                  clock,
+		InsuranceRetryInterval: 10 * time.Second,
+	})
+
+	storage.down.Store(true)
+	_, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), storage.calls.Load())
+
+	storage.down.Store(false)
+
+	// The storage is left alone until the retry interval has passed
+	clock.Advance(5 * time.Second)
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result.TotalHits)
+	assert.Equal(t, int64(1), storage.calls.Load())
+
+	clock.Advance(5 * time.Second)
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), result.TotalHits)
+	assert.Equal(t, int64(2), storage.calls.Load())
+}
+
+func TestInsuranceCoversAllOperations(t *testing.T) {
+	limiter, storage := newInsuredLimiter(t, &strigo.Options{Points: 5, Duration: 60})
+	storage.down.Store(true)
+
+	require.NoError(t, limiter.Block("user", 30))
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "the block is stored in the insurance limiter")
+
+	require.NoError(t, limiter.Reset("user"))
+
+	result, err = limiter.Penalty("user", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.RemainingPoints)
+
+	result, err = limiter.Reward("user", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result.RemainingPoints)
+
+	status, err := limiter.Get("user")
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	assert.Equal(t, int64(2), status.TotalHits)
+}
+
+func TestInsuranceIgnoresCancelledContext(t *testing.T) {
+	var failovers atomic.Int64
+	limiter, storage := newInsuredLimiter(t, &strigo.Options{
+		Points:     5,
+		Duration:   60,
+		OnFailover: func(err error) { failovers.Add(1) },
+	})
+	storage.down.Store(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := limiter.ConsumeCtx(ctx, "user", 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(0), failovers.Load())
+}
+
+func TestInvalidInsuranceRetryInterval(t *testing.T) {
+	_, err := strigo.New(&strigo.Options{Points: 1, Duration: 1, InsuranceRetryInterval: -time.Second})
+	assert.Error(t, err)
+}
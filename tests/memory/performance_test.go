@@ -0,0 +1,249 @@
+package memory_test
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+)
+
+// baselineMemoryStorage is MemoryStorage as of the release before Atomic:
+// one lock, and every state encoded as JSON by SetJSON and decoded by
+// GetJSON. Expired keys are not cleaned up, which the benchmarks do not need
+type baselineMemoryStorage struct {
+	data     map[string]int64
+	jsonData map[string][]byte
+	expiry   map[string]time.Time
+	mu       sync.RWMutex
+}
+
+func newBaselineMemoryStorage() *baselineMemoryStorage {
+	return &baselineMemoryStorage{
+		data:     make(map[string]int64),
+		jsonData: make(map[string][]byte),
+		expiry:   make(map[string]time.Time),
+	}
+}
+
+func (m *baselineMemoryStorage) Increment(ctx context.Context, key string, amount int64, expiry time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if exp, exists := m.expiry[key]; exists && time.Now().After(exp) {
+		delete(m.data, key)
+		delete(m.expiry, key)
+	}
+	count := m.data[key] + amount
+	m.data[key] = count
+	m.expiry[key] = time.Now().Add(expiry)
+	return count, nil
+}
+
+func (m *baselineMemoryStorage) Get(ctx context.Context, key string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if exp, exists := m.expiry[key]; exists && time.Now().After(exp) {
+		return 0, nil
+	}
+	return m.data[key], nil
+}
+
+func (m *baselineMemoryStorage) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.data, key)
+	delete(m.jsonData, key)
+	delete(m.expiry, key)
+	return nil
+}
+
+func (m *baselineMemoryStorage) SetJSON(ctx context.Context, key string, value interface{}, expiry time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	m.jsonData[key] = data
+	m.expiry[key] = time.Now().Add(expiry)
+	return nil
+}
+
+func (m *baselineMemoryStorage) GetJSON(ctx context.Context, key string, dest interface{}) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if exp, exists := m.expiry[key]; exists && time.Now().After(exp) {
+		return nil
+	}
+	data, exists := m.jsonData[key]
+	if !exists {
+		return nil
+	}
+	return json.Unmarshal(data, dest)
+}
+
+// Atomic takes the path the strategies took before Atomic existed: GetJSON
+// of the state, the strategy, and SetJSON of the changed state. That path had
+// no block check, so none is made here either
+func (m *baselineMemoryStorage) Atomic(ctx context.Context, op *strigo.AtomicOp) (*strigo.AtomicResult, error) {
+	if err := m.GetJSON(ctx, op.Key, op.State); err != nil {
+		return nil, err
+	}
+
+	result, save := op.Apply(true)
+	if save {
+		if err := m.SetJSON(ctx, op.Key, op.State, op.TTL); err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+func (m *baselineMemoryStorage) Close() error {
+	return nil
+}
+
+// The sliding window log is left out, its state grows with every point
+var benchmarkStrategies = []strigo.Strategy{
+	strigo.TokenBucket,
+	strigo.LeakyBucket,
+	strigo.FixedWindow,
+	strigo.SlidingWindowCounter,
+}
+
+func newBenchmarkLimiter(b *testing.B, strategy strigo.Strategy, store strigo.Storage) *strigo.RateLimiter {
+	limiter, err := strigo.New(&strigo.Options{Points: 1 << 40, Duration: 3600, Strategy: strategy, Store: store})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { limiter.Close() })
+	return limiter
+}
+
+// BenchmarkMemoryConsume compares MemoryStorage with the baseline store it replaced
+func BenchmarkMemoryConsume(b *testing.B) {
+	stores := []struct {
+		name     string
+		newStore func() strigo.Storage
+	}{
+		{"native", strigo.NewMemoryStorage},
+		{"baseline", func() strigo.Storage { return newBaselineMemoryStorage() }},
+	}
+
+	for _, strategy := range benchmarkStrategies {
+		for _, store := range stores {
+			b.Run(string(strategy)+"/"+store.name, func(b *testing.B) {
+				limiter := newBenchmarkLimiter(b, strategy, store.newStore())
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := limiter.Consume("user"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkMemoryConsumeParallel(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "user:" + strconv.Itoa(i)
+	}
+
+	for _, strategy := range benchmarkStrategies {
+		b.Run(string(strategy), func(b *testing.B) {
+			limiter := newBenchmarkLimiter(b, strategy, nil)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if _, err := limiter.Consume(keys[i%len(keys)]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestMemoryAtomicDoesNotAllocate(t *testing.T) {
+	storage := strigo.NewMemoryStorage()
+	defer storage.Close()
+	ctx := context.Background()
+
+	var tokens strigo.TokenBucketData
+	tokenOp := &strigo.AtomicOp{
+		Kind:     strigo.OpConsume,
+		Key:      "alloc:{user}:tb",
+		BlockKey: "alloc:{user}:block",
+		TTL:      time.Minute,
+		Now:      time.Now(),
+		State:    &tokens,
+	}
+	tokenOp.Apply = func(exists bool) (strigo.AtomicResult, bool) {
+		tokens.Tokens++
+		return strigo.AtomicResult{Exists: exists, Allowed: true}, true
+	}
+
+	var window strigo.FixedWindowData
+	windowOp := &strigo.AtomicOp{
+		Kind:     strigo.OpConsume,
+		Key:      "alloc:{user}:fw",
+		BlockKey: "alloc:{user}:block",
+		TTL:      time.Minute,
+		Now:      time.Now(),
+		State:    &window,
+	}
+	windowOp.Apply = func(exists bool) (strigo.AtomicResult, bool) {
+		window.Count++
+		return strigo.AtomicResult{Exists: exists, Allowed: true}, true
+	}
+
+	for _, op := range []*strigo.AtomicOp{tokenOp, windowOp} {
+		// The first call stores the key, later ones reuse the stored value
+		_, err := storage.Atomic(ctx, op)
+		require.NoError(t, err)
+
+		allocs := testing.AllocsPerRun(100, func() {
+			if _, err := storage.Atomic(ctx, op); err != nil {
+				t.Fatal(err)
+			}
+		})
+		assert.Zero(t, allocs, op.Key)
+	}
+	assert.Equal(t, float64(102), tokens.Tokens, "the state must be loaded and saved on every call")
+	assert.Equal(t, int64(102), window.Count)
+}
+
+func TestConsumeDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops values at random under the race detector")
+	}
+
+	for _, strategy := range []strigo.Strategy{strigo.TokenBucket, strigo.FixedWindow} {
+		limiter, err := strigo.New(&strigo.Options{Points: 1 << 40, Duration: 3600, Strategy: strategy})
+		require.NoError(t, err)
+		defer limiter.Close()
+
+		allocs := testing.AllocsPerRun(1000, func() {
+			if _, err := limiter.Consume("user"); err != nil {
+				t.Fatal(err)
+			}
+		})
+		assert.Zero(t, allocs, strategy)
+	}
+}
@@ -0,0 +1,199 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+)
+
+func TestLeakyBucketSlots(t *testing.T) {
+	limiter, clock := newClockLimiter(t, &strigo.Options{Points: 4, Duration: 1, Strategy: strigo.LeakyBucket})
+
+	// One request every 250ms
+	for i := int64(0); i < 4; i++ {
+		result := consume(t, limiter, 1)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, i*250, result.MsBeforeNext)
+	}
+
+	result := consume(t, limiter, 1)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(250), result.MsBeforeNext)
+
+	clock.Advance(100 * time.Millisecond)
+	result = consume(t, limiter, 1)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(150), result.MsBeforeNext)
+
+	clock.Advance(150 * time.Millisecond)
+	result = consume(t, limiter, 1)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(750), result.MsBeforeNext, "the request is queued behind the three waiting ones")
+}
+
+func TestLeakyBucketDrainsUnderSteadyLoad(t *testing.T) {
+	limiter, clock := newClockLimiter(t, &strigo.Options{Points: 2, Duration: 1, Strategy: strigo.LeakyBucket})
+
+	// Requests every 100ms drain the bucket at 2 per second
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		if consume(t, limiter, 1).Allowed {
+			allowed++
+		}
+		clock.Advance(100 * time.Millisecond)
+	}
+	assert.Equal(t, 11, allowed)
+}
+
+func TestExecuteEvenly(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{
+		Points:        10,
+		Window:        200 * time.Millisecond,
+		Strategy:      strigo.LeakyBucket,
+		ExecuteEvenly: true,
+	})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	// One request every 20ms
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		result, err := limiter.Consume("user")
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, int64(0), result.MsBeforeNext)
+	}
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 75*time.Millisecond)
+	assert.Less(t, elapsed, time.Second)
+}
+
+func TestExecuteEvenlyCanceled(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{
+		Points:        2,
+		Duration:      2,
+		Strategy:      strigo.LeakyBucket,
+		ExecuteEvenly: true,
+	})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	_, err = limiter.Consume("user")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = limiter.ConsumeCtx(ctx, "user")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	state, err := limiter.Get("user")
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, int64(1), state.ConsumedPoints, "the points of the canceled request must be given back")
+}
+
+func TestExecuteEvenlyCanceledKeepsLaterSlots(t *testing.T) {
+	store := strigo.NewMemoryStorage()
+	options := strigo.Options{Points: 4, Duration: 4, Strategy: strigo.LeakyBucket, KeyPrefix: "queue", Store: store}
+	queueing, err := strigo.New(&options)
+	require.NoError(t, err)
+	options.ExecuteEvenly = true
+	waiting, err := strigo.New(&options)
+	require.NoError(t, err)
+
+	first, err := queueing.Consume("user")
+	require.NoError(t, err)
+	require.True(t, first.Allowed)
+
+	// The waiting request is queued for 1s from now, and a 2 point request
+	// for 2s from now behind it, before the wait is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		_, err := waiting.ConsumeCtx(ctx, "user")
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	last, err := queueing.Consume("user", 2)
+	require.NoError(t, err)
+	require.True(t, last.Allowed)
+	assert.ErrorIs(t, <-done, context.DeadlineExceeded)
+
+	// Only the slot of the canceled request is given up
+	var state strigo.LeakyBucketData
+	require.NoError(t, store.GetJSON(context.Background(), "queue:{user}:lb", &state))
+	require.Len(t, state.Queue, 2)
+	assert.Equal(t, int64(1), state.Queue[0].Points)
+	assert.Equal(t, int64(2), state.Queue[1].Points)
+	assert.Greater(t, state.Queue[1].Timestamp.Sub(state.Queue[0].Timestamp), 1900*time.Millisecond)
+}
+
+func TestLeakyBucketAllowedResultHeaders(t *testing.T) {
+	limiter, _ := newClockLimiter(t, &strigo.Options{Points: 2, Duration: 2, Strategy: strigo.LeakyBucket})
+
+	consume(t, limiter, 1)
+	result := consume(t, limiter, 1)
+	require.True(t, result.Allowed)
+	assert.Equal(t, int64(1000), result.MsBeforeNext, "allowed consumes report the delay until their slot")
+
+	for _, style := range []strigo.HeaderStyle{strigo.HeaderStyleLegacy, strigo.HeaderStyleIETF, strigo.HeaderStyleBoth} {
+		assert.NotContains(t, result.HeadersFor(style), "Retry-After", style)
+	}
+}
+
+func TestExecuteEvenlyConsumeMany(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{
+		Points:        10,
+		Window:        200 * time.Millisecond,
+		Strategy:      strigo.LeakyBucket,
+		ExecuteEvenly: true,
+	})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	// The third request of "user" is due 40ms from now
+	start := time.Now()
+	results, err := limiter.ConsumeMany([]string{"user", "user", "other", "user"})
+	require.NoError(t, err)
+	for _, result := range results {
+		assert.True(t, result.Allowed)
+		assert.Equal(t, int64(0), result.MsBeforeNext)
+	}
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 35*time.Millisecond)
+	assert.Less(t, elapsed, time.Second)
+}
+
+func TestExecuteEvenlyConsumeManyCanceled(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{
+		Points:        2,
+		Duration:      2,
+		Strategy:      strigo.LeakyBucket,
+		ExecuteEvenly: true,
+	})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = limiter.ConsumeManyCtx(ctx, []string{"user", "user", "other"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	for _, key := range []string{"user", "other"} {
+		state, err := limiter.Get(key)
+		require.NoError(t, err)
+		if state != nil {
+			assert.Zero(t, state.ConsumedPoints, "the points of %s must be given back", key)
+		}
+	}
+}
+
+func TestExecuteEvenlyRequiresLeakyBucket(t *testing.T) {
+	_, err := strigo.New(&strigo.Options{Points: 1, Duration: 1, Strategy: strigo.TokenBucket, ExecuteEvenly: true})
+	assert.Error(t, err)
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package memory_test
+
+// raceEnabled is set when the tests run with -race. sync.Pool then drops
+// pooled values at random, so pooled code paths allocate
+const raceEnabled = true
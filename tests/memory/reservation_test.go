@@ -0,0 +1,98 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+)
+
+func newWaitLimiter(t *testing.T, opts *strigo.Options) *strigo.RateLimiter {
+	limiter, err := strigo.New(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { limiter.Close() })
+	return limiter
+}
+
+func TestWaitForPoints(t *testing.T) {
+	limiter := newWaitLimiter(t, &strigo.Options{Points: 2, Window: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	start := time.Now()
+	require.NoError(t, limiter.Wait(ctx, "user"))
+	require.NoError(t, limiter.Wait(ctx, "user"))
+	assert.Less(t, time.Since(start), 40*time.Millisecond, "available points must not wait")
+
+	// One token comes back every 50ms
+	require.NoError(t, limiter.Wait(ctx, "user"))
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 40*time.Millisecond)
+	assert.Less(t, elapsed, time.Second)
+}
+
+func TestWaitExceedsDeadline(t *testing.T) {
+	limiter := newWaitLimiter(t, &strigo.Options{Points: 1, Duration: 60})
+
+	result, err := limiter.Consume("user")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	err = limiter.Wait(ctx, "user")
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "Wait must not sleep when it cannot succeed")
+
+	state, err := limiter.Get("user")
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, int64(1), state.ConsumedPoints, "the reservation must be canceled")
+}
+
+func TestWaitCanceled(t *testing.T) {
+	limiter := newWaitLimiter(t, &strigo.Options{Points: 1, Window: time.Second, Strategy: strigo.SlidingWindow})
+
+	result, err := limiter.Consume("user")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	err = limiter.Wait(ctx, "user")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	state, err := limiter.Get("user")
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, int64(1), state.ConsumedPoints, "the reservation must be canceled")
+}
+
+func TestReserveFixedWindowCarriesOver(t *testing.T) {
+	limiter, clock := newClockLimiter(t, &strigo.Options{Points: 2, Duration: 10, Strategy: strigo.FixedWindow})
+
+	consume(t, limiter, 2)
+	reservation, err := limiter.Reserve("user", 2)
+	require.NoError(t, err)
+	require.True(t, reservation.OK())
+	assert.Equal(t, 10*time.Second, reservation.Delay())
+
+	// The reserved points fill the next window
+	clock.Advance(reservation.Delay())
+	assert.False(t, consume(t, limiter, 1).Allowed)
+
+	state, err := limiter.Get("user")
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, int64(0), state.RemainingPoints)
+
+	clock.Advance(10 * time.Second)
+	assert.True(t, consume(t, limiter, 1).Allowed)
+}
@@ -0,0 +1,152 @@
+package memory_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/storagetest"
+)
+
+func TestMemoryStorageConformance(t *testing.T) {
+	storagetest.Run(t, strigo.NewMemoryStorage)
+}
+
+// Run with -race to check the storage for data races as well
+func TestMemoryConcurrentConsume(t *testing.T) {
+	for _, strategy := range allStrategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			// The clock stands still, so no points come back during the test
+			limiter, _ := newClockLimiter(t, &strigo.Options{Points: 50, Duration: 60, Strategy: strategy})
+
+			var (
+				wg      sync.WaitGroup
+				allowed atomic.Int64
+			)
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 10; j++ {
+						result, err := limiter.Consume("user", 1)
+						if !assert.NoError(t, err) {
+							return
+						}
+						if result.Allowed {
+							allowed.Add(1)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, int64(50), allowed.Load(), "concurrent consumers must never be over-admitted")
+		})
+	}
+}
+
+func TestMemoryConcurrentKeys(t *testing.T) {
+	for _, strategy := range allStrategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			limiter, _ := newClockLimiter(t, &strigo.Options{Points: 5, Duration: 60, Strategy: strategy})
+
+			const keys = 100
+			var (
+				wg      sync.WaitGroup
+				allowed [keys]atomic.Int64
+			)
+			for i := 0; i < keys; i++ {
+				for j := 0; j < 4; j++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						for k := 0; k < 3; k++ {
+							result, err := limiter.Consume(fmt.Sprintf("user-%d", i), 1)
+							if !assert.NoError(t, err) {
+								return
+							}
+							if result.Allowed {
+								allowed[i].Add(1)
+							}
+						}
+					}(i)
+				}
+			}
+			wg.Wait()
+
+			for i := range allowed {
+				assert.Equal(t, int64(5), allowed[i].Load(), "key user-%d", i)
+			}
+		})
+	}
+}
+
+func TestMemoryConcurrentOperations(t *testing.T) {
+	limiter, _ := newClockLimiter(t, &strigo.Options{Points: 10, Duration: 60, BlockDuration: 1})
+
+	// Every operation touching the same keys at once must be free of data races
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("user-%d", i%2)
+			for j := 0; j < 50; j++ {
+				var err error
+				switch j % 6 {
+				case 0, 1:
+					_, err = limiter.Consume(key, 1)
+				case 2:
+					_, err = limiter.Get(key)
+				case 3:
+					_, err = limiter.Penalty(key, 1)
+				case 4:
+					_, err = limiter.Reward(key, 1)
+				case 5:
+					err = limiter.Reset(key)
+				}
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	results, err := limiter.ConsumeMany([]string{"user-0", "user-1"}, 1)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
+// The empty key would have an empty hash tag, which Redis Cluster ignores
+func TestEmptyKeyRejected(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{Points: 5, Duration: 60})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	_, err = limiter.Consume("")
+	assert.Error(t, err, "Consume")
+	_, err = limiter.Get("")
+	assert.Error(t, err, "Get")
+	_, err = limiter.ConsumeMany([]string{"user", ""})
+	assert.Error(t, err, "ConsumeMany")
+	_, err = limiter.GetMany([]string{""})
+	assert.Error(t, err, "GetMany")
+	_, err = limiter.Penalty("", 1)
+	assert.Error(t, err, "Penalty")
+	_, err = limiter.Reward("", 1)
+	assert.Error(t, err, "Reward")
+	_, err = limiter.Reserve("")
+	assert.Error(t, err, "Reserve")
+	assert.Error(t, limiter.Block("", 60), "Block")
+	assert.Error(t, limiter.Reset(""), "Reset")
+
+	// A rejected batch consumes nothing
+	result, err := limiter.Get("user")
+	require.NoError(t, err)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,103 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+)
+
+func TestSubSecondWindow(t *testing.T) {
+	strategies := []strigo.Strategy{strigo.TokenBucket, strigo.LeakyBucket, strigo.SlidingWindow, strigo.FixedWindow}
+
+	for _, strategy := range strategies {
+		strategy := strategy
+		t.Run(string(strategy), func(t *testing.T) {
+			t.Parallel()
+
+			// 20 requests per 250ms
+			limiter, err := strigo.New(&strigo.Options{
+				Points:   20,
+				Window:   250 * time.Millisecond,
+				Strategy: strategy,
+			})
+			require.NoError(t, err)
+			defer limiter.Close()
+
+			// Fixed windows are aligned to the clock, start right after a boundary
+			if strategy == strigo.FixedWindow {
+				time.Sleep(250*time.Millisecond - time.Duration(time.Now().UnixNano())%(250*time.Millisecond))
+			}
+
+			for i := 0; i < 20; i++ {
+				result, err := limiter.Consume("rpc", 1)
+				require.NoError(t, err)
+				require.True(t, result.Allowed, "request %d should be allowed", i+1)
+			}
+
+			result, err := limiter.Consume("rpc", 1)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.LessOrEqual(t, result.MsBeforeNext, int64(250))
+
+			time.Sleep(300 * time.Millisecond)
+
+			result, err = limiter.Consume("rpc", 1)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed, "points must be available again after the window")
+		})
+	}
+}
+
+func TestBlockWindow(t *testing.T) {
+	limiter, err := strigo.New(&strigo.Options{
+		Points:      1,
+		Window:      100 * time.Millisecond,
+		BlockWindow: 400 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	_, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+
+	result, err := limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(400), result.MsBeforeNext)
+
+	// Still blocked after the window refilled
+	time.Sleep(200 * time.Millisecond)
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	time.Sleep(250 * time.Millisecond)
+	result, err = limiter.Consume("user", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestWindowOptions(t *testing.T) {
+	opts := &strigo.Options{Points: 1, Duration: 60, Window: 500 * time.Millisecond}
+	require.NoError(t, opts.Validate())
+	assert.Equal(t, 500*time.Millisecond, opts.GetDuration(), "Window takes precedence over Duration")
+
+	opts = &strigo.Options{Points: 1, Duration: 2, BlockDuration: 3}
+	require.NoError(t, opts.Validate())
+	assert.Equal(t, 2*time.Second, opts.GetDuration())
+	assert.Equal(t, 3*time.Second, opts.GetBlockDuration())
+
+	invalid := []*strigo.Options{
+		{Points: 1},
+		{Points: 1, Window: -time.Second},
+		{Points: 1, Window: 1500 * time.Microsecond},
+		{Points: 1, Window: time.Second, BlockWindow: -time.Second},
+		{Points: 1, Window: time.Second, BlockWindow: time.Microsecond},
+	}
+	for _, opts := range invalid {
+		assert.Error(t, opts.Validate(), "%+v", opts)
+	}
+}
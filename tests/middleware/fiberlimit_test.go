@@ -0,0 +1,281 @@
+package middleware_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/middleware/fiberlimit"
+)
+
+func fiberRequest(t *testing.T, app *fiber.App, method, path string, headers map[string]string) *http.Response {
+	r := httptest.NewRequest(method, path, nil)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	resp, err := app.Test(r)
+	require.NoError(t, err)
+	return resp
+}
+
+func ok(c *fiber.Ctx) error {
+	return c.SendString("ok")
+}
+
+func TestFiberLimitByIP(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", fiberlimit.New(newLimiter(t, 2), nil), ok)
+
+	for i := 0; i < 2; i++ {
+		resp := fiberRequest(t, app, http.MethodGet, "/", nil)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("X-RateLimit-Limit"))
+	}
+
+	resp := fiberRequest(t, app, http.MethodGet, "/", nil)
+	assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "Rate limit exceeded")
+}
+
+func TestFiberLimitPerRouteCost(t *testing.T) {
+	limiter := newLimiter(t, 10)
+
+	app := fiber.New()
+	app.Get("/data", fiberlimit.New(limiter, nil), ok)
+	app.Get("/report", fiberlimit.New(limiter, &fiberlimit.Options{Points: 5}), ok)
+
+	resp := fiberRequest(t, app, http.MethodGet, "/report", nil)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get("X-RateLimit-Remaining"))
+
+	resp = fiberRequest(t, app, http.MethodGet, "/data", nil)
+	assert.Equal(t, "4", resp.Header.Get("X-RateLimit-Remaining"))
+
+	resp = fiberRequest(t, app, http.MethodGet, "/report", nil)
+	assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestFiberLimitKeyGenerators(t *testing.T) {
+	limiter := newLimiter(t, 1)
+
+	app := fiber.New()
+	app.Get("/header", fiberlimit.New(limiter, &fiberlimit.Options{
+		KeyGenerator: fiberlimit.KeyByHeader("X-API-Key"),
+	}), ok)
+	app.Get("/a", fiberlimit.New(limiter, &fiberlimit.Options{KeyGenerator: fiberlimit.KeyByRouteAndIP}), ok)
+	app.Get("/b", fiberlimit.New(limiter, &fiberlimit.Options{KeyGenerator: fiberlimit.KeyByRouteAndIP}), ok)
+
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/header", map[string]string{"X-API-Key": "one"}).StatusCode)
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/header", map[string]string{"X-API-Key": "two"}).StatusCode)
+	assert.Equal(t, fiber.StatusTooManyRequests, fiberRequest(t, app, http.MethodGet, "/header", map[string]string{"X-API-Key": "one"}).StatusCode)
+
+	// Each route has its own points for the same client
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/a", nil).StatusCode)
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/b", nil).StatusCode)
+	assert.Equal(t, fiber.StatusTooManyRequests, fiberRequest(t, app, http.MethodGet, "/a", nil).StatusCode)
+}
+
+func TestFiberLimitKeyByRouteAndIPWithUse(t *testing.T) {
+	app := fiber.New()
+	app.Use(fiberlimit.New(newLimiter(t, 1), &fiberlimit.Options{KeyGenerator: fiberlimit.KeyByRouteAndIP}))
+	app.Get("/a", ok)
+	app.Get("/b", ok)
+
+	// Mounted before routing, the routes are still limited separately
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/a", nil).StatusCode)
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/b", nil).StatusCode)
+	assert.Equal(t, fiber.StatusTooManyRequests, fiberRequest(t, app, http.MethodGet, "/a", nil).StatusCode)
+	assert.Equal(t, fiber.StatusTooManyRequests, fiberRequest(t, app, http.MethodGet, "/A/", nil).StatusCode, "paths routed alike share a key")
+}
+
+func TestFiberLimitKeyByRouteAndIPWithParams(t *testing.T) {
+	app := fiber.New()
+	app.Get("/users/:id", fiberlimit.New(newLimiter(t, 1), &fiberlimit.Options{KeyGenerator: fiberlimit.KeyByRouteAndIP}), ok)
+
+	// All requests to the route share its points, whatever the parameters
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/users/1", nil).StatusCode)
+	assert.Equal(t, fiber.StatusTooManyRequests, fiberRequest(t, app, http.MethodGet, "/users/2", nil).StatusCode)
+}
+
+// Before routing the parameters of the route are unknown, so each path counts
+func TestFiberLimitKeyByRouteAndIPWithUseAndParams(t *testing.T) {
+	app := fiber.New()
+	app.Use(fiberlimit.New(newLimiter(t, 1), &fiberlimit.Options{KeyGenerator: fiberlimit.KeyByRouteAndIP}))
+	app.Get("/users/:id", ok)
+
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/users/1", nil).StatusCode)
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/users/2", nil).StatusCode)
+	assert.Equal(t, fiber.StatusTooManyRequests, fiberRequest(t, app, http.MethodGet, "/users/1", nil).StatusCode)
+}
+
+// Keys outlive the request, so they must not share its reused buffers
+func TestFiberLimitKeysOutliveRequest(t *testing.T) {
+	generators := []struct {
+		name      string
+		generator func(c *fiber.Ctx) string
+		want      string
+	}{
+		{"header", fiberlimit.KeyByHeader("X-API-Key"), "first-key"},
+		{"route_and_ip", fiberlimit.KeyByRouteAndIP, "GET:/first:0.0.0.0"},
+	}
+
+	for _, tc := range generators {
+		t.Run(tc.name, func(t *testing.T) {
+			var keys []string
+			app := fiber.New()
+			app.Use(func(c *fiber.Ctx) error {
+				keys = append(keys, tc.generator(c))
+				return c.Next()
+			})
+			app.Get("/*", ok)
+
+			fiberRequest(t, app, http.MethodGet, "/first", map[string]string{"X-API-Key": "first-key"})
+			for i := 0; i < 10; i++ {
+				fiberRequest(t, app, http.MethodGet, "/other", map[string]string{"X-API-Key": "other-key"})
+			}
+			assert.Equal(t, tc.want, keys[0])
+		})
+	}
+}
+
+func TestFiberLimitCustomHandlers(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", fiberlimit.New(newLimiter(t, 1), &fiberlimit.Options{
+		Next: func(c *fiber.Ctx) bool { return c.Get("X-Internal") != "" },
+		LimitReached: func(c *fiber.Ctx, result *strigo.Result) error {
+			return c.Status(fiber.StatusServiceUnavailable).SendString("slow down")
+		},
+	}), ok)
+
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/", nil).StatusCode)
+
+	resp := fiberRequest(t, app, http.MethodGet, "/", nil)
+	assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "slow down", string(body))
+
+	// Skipped requests are not limited
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/", map[string]string{"X-Internal": "1"}).StatusCode)
+}
+
+func TestFiberLimitSkipSuccessfulRequests(t *testing.T) {
+	limiter := newLimiter(t, 2)
+
+	app := fiber.New()
+	app.Post("/login", fiberlimit.New(limiter, &fiberlimit.Options{SkipSuccessfulRequests: true}), func(c *fiber.Ctx) error {
+		if c.Get("X-Password") == "secret" {
+			return c.SendString("welcome")
+		}
+		return c.SendStatus(fiber.StatusUnauthorized)
+	})
+
+	good := map[string]string{"X-Password": "secret"}
+	bad := map[string]string{"X-Password": "wrong"}
+
+	// Successful logins are never counted
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodPost, "/login", good).StatusCode)
+	}
+
+	assert.Equal(t, fiber.StatusUnauthorized, fiberRequest(t, app, http.MethodPost, "/login", bad).StatusCode)
+	assert.Equal(t, fiber.StatusUnauthorized, fiberRequest(t, app, http.MethodPost, "/login", bad).StatusCode)
+
+	// Two failures exhaust the points, so even a correct password is rejected
+	assert.Equal(t, fiber.StatusTooManyRequests, fiberRequest(t, app, http.MethodPost, "/login", good).StatusCode)
+}
+
+// Requests in flight hold their points, so concurrent failures cannot all pass
+func TestFiberLimitSkipSuccessfulRequestsConcurrent(t *testing.T) {
+	const requests = 10
+	limiter := newLimiter(t, 2)
+
+	var entered atomic.Int32
+	release := make(chan struct{})
+
+	app := fiber.New()
+	app.Post("/login", fiberlimit.New(limiter, &fiberlimit.Options{SkipSuccessfulRequests: true}), func(c *fiber.Ctx) error {
+		entered.Add(1)
+		<-release
+		return c.SendStatus(fiber.StatusUnauthorized)
+	})
+
+	statuses := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			resp, err := app.Test(httptest.NewRequest(http.MethodPost, "/login", nil), 5000)
+			if !assert.NoError(t, err) {
+				statuses <- 0
+				return
+			}
+			statuses <- resp.StatusCode
+		}()
+	}
+
+	// The requests beyond the limit are rejected without waiting for the others
+	for i := 0; i < requests-2; i++ {
+		assert.Equal(t, fiber.StatusTooManyRequests, <-statuses)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, fiber.StatusUnauthorized, <-statuses)
+	}
+	assert.Equal(t, int32(2), entered.Load())
+}
+
+func TestFiberLimitSkipFailedRequests(t *testing.T) {
+	limiter := newLimiter(t, 1)
+
+	app := fiber.New()
+	app.Get("/", fiberlimit.New(limiter, &fiberlimit.Options{SkipFailedRequests: true}), func(c *fiber.Ctx) error {
+		if c.Query("fail") != "" {
+			return fiber.ErrBadGateway
+		}
+		return c.SendString("ok")
+	})
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, fiber.StatusBadGateway, fiberRequest(t, app, http.MethodGet, "/?fail=1", nil).StatusCode)
+	}
+
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/", nil).StatusCode)
+	assert.Equal(t, fiber.StatusTooManyRequests, fiberRequest(t, app, http.MethodGet, "/", nil).StatusCode)
+}
+
+func TestFiberLimitErrorPolicy(t *testing.T) {
+	limiter := newLimiter(t, 1)
+
+	// A cancelled user context makes every limiter call fail
+	cancelled := func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithCancel(c.UserContext())
+		cancel()
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+
+	app := fiber.New()
+	app.Get("/closed", cancelled, fiberlimit.New(limiter, nil), ok)
+	app.Get("/open", cancelled, fiberlimit.New(limiter, &fiberlimit.Options{FailOpen: true}), ok)
+	app.Get("/custom", cancelled, fiberlimit.New(limiter, &fiberlimit.Options{
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			assert.ErrorIs(t, err, context.Canceled)
+			return c.SendStatus(fiber.StatusServiceUnavailable)
+		},
+	}), ok)
+
+	assert.Equal(t, fiber.StatusInternalServerError, fiberRequest(t, app, http.MethodGet, "/closed", nil).StatusCode)
+	assert.Equal(t, fiber.StatusOK, fiberRequest(t, app, http.MethodGet, "/open", nil).StatusCode)
+	assert.Equal(t, fiber.StatusServiceUnavailable, fiberRequest(t, app, http.MethodGet, "/custom", nil).StatusCode)
+}
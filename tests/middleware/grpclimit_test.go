@@ -0,0 +1,184 @@
+package middleware_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2/middleware/grpclimit"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	testgrpc "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type testService struct {
+	testgrpc.UnimplementedTestServiceServer
+}
+
+func (testService) EmptyCall(ctx context.Context, req *testgrpc.Empty) (*testgrpc.Empty, error) {
+	return &testgrpc.Empty{}, nil
+}
+
+func (testService) UnaryCall(ctx context.Context, req *testgrpc.SimpleRequest) (*testgrpc.SimpleResponse, error) {
+	return &testgrpc.SimpleResponse{}, nil
+}
+
+func (testService) StreamingInputCall(stream testgrpc.TestService_StreamingInputCallServer) error {
+	var total int32
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&testgrpc.StreamingInputCallResponse{AggregatedPayloadSize: total})
+		}
+		if err != nil {
+			return err
+		}
+		total += int32(len(req.GetPayload().GetBody()))
+	}
+}
+
+// newGRPCClient serves the test service over an in-memory connection
+func newGRPCClient(t *testing.T, opts ...grpc.ServerOption) testgrpc.TestServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(opts...)
+	testgrpc.RegisterTestServiceServer(server, testService{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return testgrpc.NewTestServiceClient(conn)
+}
+
+func TestGRPCUnaryInterceptor(t *testing.T) {
+	client := newGRPCClient(t, grpc.UnaryInterceptor(grpclimit.UnaryServerInterceptor(newLimiter(t, 2), nil)))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		var trailer metadata.MD
+		_, err := client.EmptyCall(ctx, &testgrpc.Empty{}, grpc.Trailer(&trailer))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2"}, trailer.Get("x-ratelimit-limit"))
+	}
+
+	var trailer metadata.MD
+	_, err := client.EmptyCall(ctx, &testgrpc.Empty{}, grpc.Trailer(&trailer))
+	require.Error(t, err)
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	assert.Equal(t, []string{"0"}, trailer.Get("x-ratelimit-remaining"))
+	assert.NotEmpty(t, trailer.Get("retry-after"))
+
+	require.Len(t, st.Details(), 1)
+	retryInfo, ok := st.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	assert.Greater(t, retryInfo.GetRetryDelay().AsDuration().Milliseconds(), int64(0))
+}
+
+func TestGRPCKeyFuncs(t *testing.T) {
+	client := newGRPCClient(t, grpc.UnaryInterceptor(grpclimit.UnaryServerInterceptor(newLimiter(t, 1), &grpclimit.Options{
+		KeyFunc: grpclimit.Keys(grpclimit.KeyByMetadata("x-api-key"), grpclimit.KeyByMethod),
+	})))
+
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+	}
+
+	_, err := client.EmptyCall(withKey("one"), &testgrpc.Empty{})
+	require.NoError(t, err)
+
+	// Each method has its own points for the same key
+	_, err = client.UnaryCall(withKey("one"), &testgrpc.SimpleRequest{})
+	require.NoError(t, err)
+
+	_, err = client.EmptyCall(withKey("two"), &testgrpc.Empty{})
+	require.NoError(t, err)
+
+	_, err = client.EmptyCall(withKey("one"), &testgrpc.Empty{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Fail closed without the metadata key
+	_, err = client.EmptyCall(context.Background(), &testgrpc.Empty{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestGRPCSkipAndFailOpen(t *testing.T) {
+	client := newGRPCClient(t, grpc.UnaryInterceptor(grpclimit.UnaryServerInterceptor(newLimiter(t, 1), &grpclimit.Options{
+		KeyFunc: grpclimit.KeyByMetadata("x-api-key"),
+		Skip: func(ctx context.Context, fullMethod string) bool {
+			return fullMethod == "/grpc.testing.TestService/UnaryCall"
+		},
+		FailOpen: true,
+	})))
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.EmptyCall(ctx, &testgrpc.Empty{})
+		assert.NoError(t, err, "calls without a key must fail open")
+
+		_, err = client.UnaryCall(ctx, &testgrpc.SimpleRequest{})
+		assert.NoError(t, err, "skipped methods must not be limited")
+	}
+}
+
+func TestGRPCStreamInterceptor(t *testing.T) {
+	client := newGRPCClient(t, grpc.StreamInterceptor(grpclimit.StreamServerInterceptor(newLimiter(t, 1), nil)))
+	ctx := context.Background()
+
+	send := func() error {
+		stream, err := client.StreamingInputCall(ctx)
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			if err := stream.Send(&testgrpc.StreamingInputCallRequest{Payload: &testgrpc.Payload{Body: []byte("x")}}); err != nil {
+				break
+			}
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	// Points are consumed once per stream, whatever the number of messages
+	require.NoError(t, send())
+	assert.Equal(t, codes.ResourceExhausted, status.Code(send()))
+}
+
+func TestGRPCStreamPerMessage(t *testing.T) {
+	limiter := newLimiter(t, 3)
+	client := newGRPCClient(t, grpc.StreamInterceptor(grpclimit.StreamServerInterceptor(limiter, &grpclimit.Options{
+		PerMessage: true,
+	})))
+
+	stream, err := client.StreamingInputCall(context.Background())
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		if err := stream.Send(&testgrpc.StreamingInputCallRequest{Payload: &testgrpc.Payload{Body: []byte("x")}}); err != nil {
+			break
+		}
+	}
+
+	_, err = stream.CloseAndRecv()
+	trailer := stream.Trailer()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "the fourth message must be rejected")
+	assert.Equal(t, []string{"0"}, trailer.Get("x-ratelimit-remaining"))
+
+	state, err := limiter.Get("bufconn")
+	require.NoError(t, err)
+	require.NotNil(t, state, "bufconn peers are keyed by their full address")
+	assert.Equal(t, int64(0), state.RemainingPoints)
+}
@@ -0,0 +1,138 @@
+package middleware_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/middleware/httplimit"
+)
+
+func newLimiter(t *testing.T, points int64) *strigo.RateLimiter {
+	limiter, err := strigo.New(&strigo.Options{Points: points, Duration: 60})
+	require.NoError(t, err)
+	t.Cleanup(func() { limiter.Close() })
+	return limiter
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func serve(handler http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestHTTPLimitByIP(t *testing.T) {
+	handler := httplimit.New(newLimiter(t, 2), nil)(okHandler)
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := serve(handler, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:5678"
+	w := serve(handler, r)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "Rate limit exceeded")
+
+	// A different client has its own points
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	assert.Equal(t, http.StatusOK, serve(handler, r).Code)
+}
+
+func TestHTTPLimitCostAndCustomResponse(t *testing.T) {
+	handler := httplimit.New(newLimiter(t, 10), &httplimit.Options{
+		KeyFunc: httplimit.KeyByHeader("X-API-Key"),
+		CostFunc: func(r *http.Request) int64 {
+			if r.URL.Path == "/report" {
+				return 6
+			}
+			return 1
+		},
+		LimitReached: func(w http.ResponseWriter, r *http.Request, result *strigo.Result) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	})(okHandler)
+
+	r := httptest.NewRequest(http.MethodGet, "/report", nil)
+	r.Header.Set("X-API-Key", "key1")
+	w := serve(handler, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "4", w.Header().Get("X-RateLimit-Remaining"))
+
+	r = httptest.NewRequest(http.MethodGet, "/report", nil)
+	r.Header.Set("X-API-Key", "key1")
+	assert.Equal(t, http.StatusServiceUnavailable, serve(handler, r).Code)
+}
+
+func TestHTTPLimitSkipAndAllowList(t *testing.T) {
+	handler := httplimit.New(newLimiter(t, 1), &httplimit.Options{
+		Skip:      func(r *http.Request) bool { return r.URL.Path == "/health" },
+		AllowList: []string{"192.168.1.1"},
+	})(okHandler)
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/health", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		assert.Equal(t, http.StatusOK, serve(handler, r).Code)
+
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.168.1.1:1234"
+		assert.Equal(t, http.StatusOK, serve(handler, r).Code)
+	}
+}
+
+func TestHTTPLimitErrorPolicy(t *testing.T) {
+	limiter := newLimiter(t, 1)
+	failingKey := func(r *http.Request) (string, error) {
+		return "", errors.New("no key")
+	}
+
+	closed := httplimit.New(limiter, &httplimit.Options{KeyFunc: failingKey})(okHandler)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusInternalServerError, serve(closed, r).Code)
+
+	open := httplimit.New(limiter, &httplimit.Options{KeyFunc: failingKey, FailOpen: true})(okHandler)
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusOK, serve(open, r).Code)
+}
+
+func TestHTTPLimitKeyFuncs(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","tenant":12345678}`))
+	r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("Authorization", "Bearer header."+payload+".signature")
+
+	key, err := httplimit.KeyByJWTClaim("sub")(r)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", key)
+
+	key, err = httplimit.KeyByJWTClaim("tenant")(r)
+	require.NoError(t, err)
+	assert.Equal(t, "12345678", key)
+
+	_, err = httplimit.KeyByJWTClaim("missing")(r)
+	assert.Error(t, err)
+
+	key, err = httplimit.Keys(httplimit.KeyByIP, httplimit.KeyByPath)(r)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:/api/users", key)
+
+	_, err = httplimit.KeyByHeader("X-Missing")(r)
+	assert.Error(t, err)
+}
@@ -0,0 +1,181 @@
+package redis_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/storagetest"
+	"github.com/veyselaksin/strigo/v2/tests/helpers"
+)
+
+func TestRedisStorageConformance(t *testing.T) {
+	redisClient := helpers.NewRedisClient()
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		t.Skip("Redis not available, skipping storage conformance tests")
+	}
+	defer helpers.CleanupRedis(t, redisClient)
+
+	storagetest.Run(t, func() strigo.Storage {
+		storage, err := strigo.NewRedisStorage(helpers.NewRedisClient())
+		require.NoError(t, err)
+		return storage
+	})
+}
+
+// Limiters with their own clients stand in for processes sharing Redis
+func TestRedisConcurrentProcesses(t *testing.T) {
+	redisClient := helpers.NewRedisClient()
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		t.Skip("Redis not available, skipping concurrency tests")
+	}
+	defer helpers.CleanupRedis(t, redisClient)
+
+	strategies := []strigo.Strategy{
+		strigo.TokenBucket,
+		strigo.LeakyBucket,
+		strigo.SlidingWindow,
+		strigo.FixedWindow,
+		strigo.SlidingWindowCounter,
+	}
+
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			var (
+				wg      sync.WaitGroup
+				mu      sync.Mutex
+				allowed int
+			)
+
+			for p := 0; p < 4; p++ {
+				limiter, err := strigo.New(&strigo.Options{
+					Points:      20,
+					Duration:    60,
+					Strategy:    strategy,
+					KeyPrefix:   "script_test",
+					StoreClient: helpers.NewRedisClient(),
+				})
+				require.NoError(t, err)
+				defer limiter.Close()
+
+				for i := 0; i < 15; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						result, err := limiter.Consume("shared", 1)
+						if !assert.NoError(t, err) {
+							return
+						}
+						if result.Allowed {
+							mu.Lock()
+							allowed++
+							mu.Unlock()
+						}
+					}()
+				}
+			}
+			wg.Wait()
+
+			assert.Equal(t, 20, allowed, "concurrent processes must never be over-admitted")
+		})
+	}
+}
+
+func TestRedisStorageConformanceBinaryCodec(t *testing.T) {
+	redisClient := helpers.NewRedisClient()
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		t.Skip("Redis not available, skipping storage conformance tests")
+	}
+	defer helpers.CleanupRedis(t, redisClient)
+
+	storagetest.Run(t, func() strigo.Storage {
+		storage, err := strigo.NewRedisStorageWithCodec(helpers.NewRedisClient(), strigo.BinaryCodec)
+		require.NoError(t, err)
+		return storage
+	})
+}
+
+// Limits survive switching the codec, states written by either codec are
+// read by the other
+func TestRedisCodecSwitchKeepsState(t *testing.T) {
+	redisClient := helpers.NewRedisClient()
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		t.Skip("Redis not available, skipping codec tests")
+	}
+	defer helpers.CleanupRedis(t, redisClient)
+
+	strategies := []strigo.Strategy{
+		strigo.TokenBucket,
+		strigo.LeakyBucket,
+		strigo.SlidingWindow,
+		strigo.FixedWindow,
+		strigo.SlidingWindowCounter,
+	}
+
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			newLimiter := func(codec strigo.Codec) *strigo.RateLimiter {
+				limiter, err := strigo.New(&strigo.Options{
+					Points:      5,
+					Duration:    60,
+					Strategy:    strategy,
+					KeyPrefix:   "codec_test",
+					StoreClient: helpers.NewRedisClient(),
+					Codec:       codec,
+				})
+				require.NoError(t, err)
+				t.Cleanup(func() { limiter.Close() })
+				return limiter
+			}
+			jsonLimiter := newLimiter(strigo.JSONCodec)
+			binaryLimiter := newLimiter(strigo.BinaryCodec)
+
+			result, err := jsonLimiter.Consume("user", 2)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), result.RemainingPoints)
+
+			result, err = binaryLimiter.Consume("user", 2)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), result.RemainingPoints, "binary codec must read the JSON state")
+
+			result, err = jsonLimiter.Consume("user", 2)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed, "JSON codec must read the binary state")
+			assert.Equal(t, int64(1), result.RemainingPoints)
+		})
+	}
+}
+
+// The scripts write a binary layout of their own, which BinaryCodec in Go
+// must not mistake for its own
+func TestRedisBinaryStateVersion(t *testing.T) {
+	redisClient := helpers.NewRedisClient()
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		t.Skip("Redis not available, skipping codec tests")
+	}
+	defer helpers.CleanupRedis(t, redisClient)
+
+	limiter, err := strigo.New(&strigo.Options{
+		Points:      5,
+		Duration:    60,
+		KeyPrefix:   "codec_version",
+		StoreClient: helpers.NewRedisClient(),
+		Codec:       strigo.BinaryCodec,
+	})
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	_, err = limiter.Consume("user")
+	require.NoError(t, err)
+
+	raw, err := redisClient.Get(context.Background(), "codec_version:{user}:tb").Bytes()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(raw), 2)
+	assert.Equal(t, []byte{0xb7, 2}, raw[:2])
+
+	var state strigo.TokenBucketData
+	assert.Error(t, strigo.BinaryCodec.Unmarshal(raw, &state))
+}
@@ -0,0 +1,133 @@
+package redis_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veyselaksin/strigo/v2"
+	"github.com/veyselaksin/strigo/v2/tests/helpers"
+)
+
+func TestRedisUniversalClients(t *testing.T) {
+	rdb := helpers.NewRedisClient()
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skip("Redis not available, skipping universal client tests")
+	}
+	defer helpers.CleanupRedis(t, rdb)
+	addr := rdb.Options().Addr
+
+	clients := map[string]redis.UniversalClient{
+		"Universal": redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{addr}}),
+		"Ring":      redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"shard": addr}}),
+	}
+
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			limiter, err := strigo.New(&strigo.Options{
+				Points:        2,
+				Duration:      60,
+				BlockDuration: 30,
+				KeyPrefix:     "universal_" + name,
+				StoreClient:   client,
+			})
+			require.NoError(t, err)
+			defer limiter.Close()
+
+			for i := 0; i < 2; i++ {
+				result, err := limiter.Consume("user1", 1)
+				require.NoError(t, err)
+				assert.True(t, result.Allowed)
+			}
+
+			result, err := limiter.Consume("user1", 1)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Equal(t, int64(30000), result.MsBeforeNext)
+		})
+	}
+}
+
+func TestRedisClusterAndFailoverClients(t *testing.T) {
+	// Neither client connects before its first command, so no servers are needed
+	clients := map[string]redis.UniversalClient{
+		"Cluster": redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:7000", "127.0.0.1:7001"}}),
+		"Failover": redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    "mymaster",
+			SentinelAddrs: []string{"127.0.0.1:26379"},
+		}),
+	}
+
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			storage, err := strigo.NewRedisStorage(client)
+			require.NoError(t, err)
+			assert.NotNil(t, storage)
+
+			storage, err = strigo.NewRedisStorageWithCodec(client, strigo.BinaryCodec)
+			require.NoError(t, err)
+			assert.NotNil(t, storage)
+
+			limiter, err := strigo.New(&strigo.Options{Points: 2, Duration: 60, StoreClient: client})
+			require.NoError(t, err)
+			assert.NoError(t, limiter.Close())
+		})
+	}
+}
+
+func TestRedisKeysShareHashTag(t *testing.T) {
+	rdb := helpers.NewRedisClient()
+	ctx := context.Background()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		t.Skip("Redis not available, skipping hash tag tests")
+	}
+	defer helpers.CleanupRedis(t, rdb)
+
+	limiter, err := strigo.New(&strigo.Options{
+		Points:      1,
+		Duration:    60,
+		KeyPrefix:   "tagged",
+		StoreClient: rdb,
+	})
+	require.NoError(t, err)
+
+	_, err = limiter.Consume("user1", 1)
+	require.NoError(t, err)
+	require.NoError(t, limiter.Block("user1", 60))
+
+	keys, err := rdb.Keys(ctx, "tagged:*").Result()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"tagged:{user1}:tb", "tagged:{user1}:block"}, keys)
+
+	// The scripts touch both keys, which Redis Cluster requires in one slot
+	for _, key := range keys {
+		assert.Equal(t, keySlot("user1"), keySlot(key), key)
+	}
+	assert.NotEqual(t, keySlot("tagged:user1:tb"), keySlot("tagged:user1:block"), "keys without hash tags may differ")
+}
+
+// keySlot returns the Redis Cluster hash slot of key: CRC16 (XMODEM) of its
+// hash tag, or of the whole key without one, modulo 16384
+func keySlot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc % 16384
+}